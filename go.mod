@@ -12,4 +12,6 @@ require (
 	github.com/aquilax/truncate v1.0.0
 	github.com/montanaflynn/stats v0.6.5
 	github.com/mpvl/unique v0.0.0-20150818121801-cbe035fff7de // indirect
+	github.com/prometheus/client_model v0.2.0
+	gopkg.in/yaml.v2 v2.3.0
 )
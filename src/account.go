@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// payoutLookback is how far back the latest payout is looked for. Vast.ai
+// pays out weekly.
+const payoutLookback = 90 * 24 * time.Hour
+
+// invoiceTypePayout is the type of invoices that pay hosting earnings out.
+const invoiceTypePayout = "payout"
+
+func (c *VastCollector) collectAccountDetails(ch chan<- prometheus.Metric, account string, accountData *vastai.AccountAPI) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_balance"], prometheus.GaugeValue, accountData.Balance, account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_credit"], prometheus.GaugeValue, accountData.Credit, account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_payouts"], prometheus.GaugeValue, accountData.PaidVerified, account, "paid")
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_payouts"], prometheus.GaugeValue, accountData.PaidExpected, account, "pending")
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_billed"], prometheus.GaugeValue, accountData.BilledVerified, account, "verified")
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_billed"], prometheus.GaugeValue, accountData.BilledExpected, account, "expected")
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_balance_threshold"], prometheus.GaugeValue, accountData.BalanceThreshold, account)
+
+	flags := map[string]bool{
+		"balance_threshold_enabled": accountData.BalanceThresholdEnabled,
+		"billing_credit_only":       accountData.BillingCreditOnly,
+		"has_billing":               accountData.HasBilling,
+		"can_pay":                   accountData.CanPay,
+		"email_verified":            accountData.EmailVerified,
+	}
+	for flag, value := range flags {
+		ch <- prometheus.MustNewConstMetric(c.metrics["account_flag"], prometheus.GaugeValue, boolToFloat(value), account, flag)
+	}
+}
+
+// payoutTracker remembers the latest payout of an account, so that only
+// invoices since the previous fetch need to be fetched.
+type payoutTracker struct {
+	last *vastai.Invoice
+	// synced is the end of the last successful fetch, zero before the
+	// first one.
+	synced time.Time
+}
+
+// update fetches the invoices since the previous successful update, or of
+// the last payoutLookback at first, and returns the latest payout in the
+// last payoutLookback. Invoices of the last invoiceOverlap are fetched
+// again, in case they show up late. If fetching fails, it returns the
+// latest payout known.
+func (t *payoutTracker) update(ctx context.Context, client *vastai.Client, now time.Time) (*vastai.Invoice, error) {
+	from := now.Add(-payoutLookback)
+	if !t.synced.IsZero() && t.synced.Add(-invoiceOverlap).After(from) {
+		from = t.synced.Add(-invoiceOverlap)
+	}
+	invoices, err := client.Invoices(ctx, from, now)
+	if err == nil {
+		for _, invoice := range invoices.Invoices {
+			if invoice.Type == invoiceTypePayout && (t.last == nil || invoice.Timestamp > t.last.Timestamp) {
+				invoice := invoice
+				t.last = &invoice
+			}
+		}
+		t.synced = now
+	}
+	if t.last != nil && t.last.Timestamp < float64(now.Add(-payoutLookback).Unix()) {
+		t.last = nil
+	}
+	if t.last == nil {
+		return nil, err
+	}
+	last := *t.last
+	return &last, err
+}
+
+// collectLastPayout emits the amount and time of the latest payout. Like
+// the invoice counters, the amount is exported without its sign, whatever
+// the sign Vast.ai uses for payouts.
+func (c *VastCollector) collectLastPayout(ch chan<- prometheus.Metric, account string, last *vastai.Invoice) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_last_payout"], prometheus.GaugeValue, math.Abs(last.Amount), account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["account_last_payout_timestamp"], prometheus.GaugeValue, last.Timestamp, account)
+}
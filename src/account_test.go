@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"prometheus-vastai/src/vastai"
+)
+
+func TestLastPayoutIsPositive(t *testing.T) {
+	// The later fixtures have payouts with a negative amount, the full ones
+	// with a positive amount.
+	for _, dir := range []string{"full", "later"} {
+		collector := newFixtureCollector(t, filepath.Join("testdata", dir), CollectorOptions{})
+		expected := `
+# HELP vastai_account_last_payout_usd Amount of the latest payout in the last 90 days
+# TYPE vastai_account_last_payout_usd gauge
+vastai_account_last_payout_usd{account="test"} 301.17
+`
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "vastai_account_last_payout_usd"); err != nil {
+			t.Errorf("%s: %s", dir, err)
+		}
+	}
+}
+
+func TestPayoutTrackerFetchesSinceLastUpdate(t *testing.T) {
+	server, client := newInvoiceServer(t, testInvoices)
+	var tracker payoutTracker
+
+	last, err := tracker.update(context.Background(), client, testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.ID != 90102 {
+		t.Fatalf("latest payout = %+v, want 90102", last)
+	}
+	if want := testTime.Add(-payoutLookback).Unix(); server.ranges[0][0] != want {
+		t.Errorf("first fetch starts at %d, want %d", server.ranges[0][0], want)
+	}
+
+	// Later only the invoices since the previous fetch are fetched.
+	server.invoices = append(server.invoices, vastai.Invoice{ID: 90500, Type: "payout", Amount: -150, Timestamp: float64(testTime.Add(time.Hour).Unix())})
+	later := testTime.Add(2 * time.Hour)
+	if last, err = tracker.update(context.Background(), client, later); err != nil {
+		t.Fatal(err)
+	}
+	if last == nil || last.ID != 90500 {
+		t.Errorf("latest payout = %+v, want 90500", last)
+	}
+	if want := [2]int64{testTime.Add(-invoiceOverlap).Unix(), later.Unix()}; server.ranges[1] != want {
+		t.Errorf("second fetch = %v, want %v", server.ranges[1], want)
+	}
+
+	// A failed fetch keeps the latest payout, until it is too old.
+	server.failAt = 2
+	if last, err = tracker.update(context.Background(), client, later.Add(time.Minute)); err == nil || last == nil || last.ID != 90500 {
+		t.Errorf("after a failed fetch got %+v, %v, want 90500 and an error", last, err)
+	}
+	server.failAt = -1
+	if last, _ = tracker.update(context.Background(), client, later.Add(payoutLookback)); last != nil {
+		t.Errorf("latest payout = %+v after the lookback, want none", last)
+	}
+}
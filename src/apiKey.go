@@ -0,0 +1,91 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// apiKeyEnv is read when the key is given neither as a flag nor as a file.
+const apiKeyEnv = "VASTAI_API_KEY"
+
+// How often an API key file is checked for changes. Kubernetes updates
+// secret mounts within about a minute, so there is no point in polling
+// more often.
+const apiKeyCheckInterval = 30 * time.Second
+
+// resolveAPIKey returns the API key given on the command line, or else
+// the contents of keyFile, or else the value of $VASTAI_API_KEY.
+func resolveAPIKey(key, keyFile string) (string, error) {
+	switch {
+	case key != "" && keyFile != "":
+		return "", errors.New("only one of --api-key and --api-key-file may be given")
+	case key != "":
+		return key, nil
+	case keyFile != "":
+		return readAPIKeyFile(keyFile)
+	case os.Getenv(apiKeyEnv) != "":
+		return os.Getenv(apiKeyEnv), nil
+	}
+	return "", fmt.Errorf("API key must be provided with --api-key, --api-key-file or $%s", apiKeyEnv)
+}
+
+func readAPIKeyFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("API key file %s is empty", path)
+	}
+	return key, nil
+}
+
+// apiKeyWatcher keeps the API key of a client in sync with a file, so that
+// keys can be rotated without restarting the exporter.
+type apiKeyWatcher struct {
+	path    string
+	client  *vastai.Client
+	current string
+}
+
+// watch reloads the key on SIGHUP and whenever the file changes.
+// It never returns.
+func (w *apiKeyWatcher) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	ticker := time.NewTicker(apiKeyCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-hup:
+			log.Printf("Got SIGHUP, reloading API key from %s", w.path)
+		case <-ticker.C:
+		}
+		w.reload()
+	}
+}
+
+func (w *apiKeyWatcher) reload() {
+	key, err := readAPIKeyFile(w.path)
+	if err != nil {
+		// Keep using the old key; the file may be in the middle of an update.
+		log.Printf("Failed to reload API key: %s", err)
+		return
+	}
+	if key == w.current {
+		return
+	}
+	w.client.SetAPIKey(key)
+	w.current = key
+	log.Printf("Loaded new API key from %s", w.path)
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// runBackfill implements the backfill command, which writes the daily
+// earnings in a range of days as OpenMetrics with a timestamp per day, e.g.
+// for promtool tsdb create-blocks-from openmetrics.
+func runBackfill(args []string) error {
+	flags := flag.NewFlagSet("backfill", flag.ExitOnError)
+	apiKey := flags.String("api-key", "", "Vast.ai API key. Visible in the process list; prefer --api-key-file or $VASTAI_API_KEY.")
+	apiKeyFile := flags.String("api-key-file", "", "File containing the Vast.ai API key.")
+	accountsFile := flags.String("accounts-file", "", "YAML file listing several named Vast.ai accounts, instead of a single --api-key.")
+	apiURL := flags.String("api-url", vastai.DefaultBaseURL, "Base URL of the Vast.ai API.")
+	apiTimeout := flags.Duration("api-timeout", 30*time.Second, "Timeout of each request to the Vast.ai API.")
+	from := flags.String("from", "", "First day to fetch earnings of, as YYYY-MM-DD. Required.")
+	to := flags.String("to", "", "Last day to fetch earnings of, as YYYY-MM-DD. Defaults to yesterday, the last complete day.")
+	output := flags.String("output", "-", "File to write the OpenMetrics to, - for standard output.")
+	flags.Parse(args)
+
+	if *from == "" {
+		return errors.New("--from is required")
+	}
+	startDay, err := parseDay(*from)
+	if err != nil {
+		return err
+	}
+	endDay := dayNumber(time.Now()) - 1
+	if *to != "" {
+		if endDay, err = parseDay(*to); err != nil {
+			return err
+		}
+	}
+	if endDay < startDay {
+		return errors.New("--to must not be before --from")
+	}
+
+	accounts, err := loadAccounts(*apiKey, *apiKeyFile, *accountsFile, *apiURL, *apiTimeout, false)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	if *output == "-" {
+		return backfill(ctx, accounts, startDay, endDay, os.Stdout)
+	}
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := backfill(ctx, accounts, startDay, endDay, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func parseDay(value string) (int, error) {
+	t, err := time.Parse("2006-01-02", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid day %q, want YYYY-MM-DD", value)
+	}
+	return dayNumber(t), nil
+}
+
+// dayNumber returns the day of t in the numbering of the earnings API,
+// counted in days since the UNIX epoch.
+func dayNumber(t time.Time) int {
+	return int(t.Unix() / (24 * 60 * 60))
+}
+
+// dailyEarnings is the earnings of one day, by earning type in the order
+// of earningTypes.
+type dailyEarnings struct {
+	day    int
+	values [4]float64
+}
+
+// backfill writes the earnings of each account and each of its machines
+// per day from startDay to endDay, timestamped at the start of the day.
+func backfill(ctx context.Context, accounts []Account, startDay, endDay int, w io.Writer) error {
+	total := &dto.MetricFamily{
+		Name: stringPtr("vastai_daily_earnings_usd"),
+		Help: stringPtr("Earnings of all machines per day, by type, timestamped at the start of the day"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+	perMachine := &dto.MetricFamily{
+		Name: stringPtr("vastai_machine_daily_earnings_usd"),
+		Help: stringPtr("Earnings of the machine per day, by type, timestamped at the start of the day"),
+		Type: dto.MetricType_GAUGE.Enum(),
+	}
+
+	for _, account := range accounts {
+		query := vastai.EarningsQuery{StartDay: startDay, EndDay: endDay}
+		earnings, err := account.Client.MachineEarningsBetween(ctx, query)
+		if err != nil {
+			return fmt.Errorf("failed to fetch earnings of account %s: %w", account.Name, err)
+		}
+		total.Metric = append(total.Metric, timestampedEarnings(perDay(earnings), labelPair("account", account.Name))...)
+
+		// The earnings of all machines only hold the total per machine, the
+		// days of each machine need a query of their own.
+		for _, machine := range earnings.PerMachine {
+			query.MachineID = machine.MachineID
+			machineEarnings, err := account.Client.MachineEarningsBetween(ctx, query)
+			if err != nil {
+				return fmt.Errorf("failed to fetch earnings of machine %d of account %s: %w", machine.MachineID, account.Name, err)
+			}
+			perMachine.Metric = append(perMachine.Metric, timestampedEarnings(perDay(machineEarnings),
+				labelPair("account", account.Name), labelPair("machine_id", strconv.Itoa(machine.MachineID)))...)
+		}
+		log.Printf("Fetched earnings of account %s and its %d machines from %s to %s",
+			account.Name, len(earnings.PerMachine), formatDay(startDay), formatDay(endDay))
+	}
+
+	for _, family := range []*dto.MetricFamily{total, perMachine} {
+		if len(family.Metric) == 0 {
+			continue
+		}
+		if _, err := expfmt.MetricFamilyToOpenMetrics(w, family); err != nil {
+			return err
+		}
+	}
+	_, err := expfmt.FinalizeOpenMetrics(w)
+	return err
+}
+
+// perDay returns the daily earnings of a response, sorted by day.
+func perDay(earnings *vastai.MachineEarningsAPI) []dailyEarnings {
+	days := make([]dailyEarnings, 0, len(earnings.PerDay))
+	for _, day := range earnings.PerDay {
+		days = append(days, dailyEarnings{day.Day, [4]float64{day.GpuEarn, day.StoEarn, day.BwuEarn, day.BwdEarn}})
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].day < days[j].day })
+	return days
+}
+
+// timestampedEarnings returns a sample per earning type and day, grouped
+// by earning type so that the samples of each series are in order.
+func timestampedEarnings(days []dailyEarnings, labels ...*dto.LabelPair) []*dto.Metric {
+	var metrics []*dto.Metric
+	for i, earningType := range earningTypes {
+		for _, day := range days {
+			value := day.values[i]
+			timestamp := int64(day.day) * 24 * 60 * 60 * 1000
+			metrics = append(metrics, &dto.Metric{
+				Label:       append(append([]*dto.LabelPair(nil), labels...), labelPair("type", earningType)),
+				Gauge:       &dto.Gauge{Value: &value},
+				TimestampMs: &timestamp,
+			})
+		}
+	}
+	return metrics
+}
+
+func labelPair(name, value string) *dto.LabelPair {
+	return &dto.LabelPair{Name: &name, Value: &value}
+}
+
+func stringPtr(s string) *string {
+	return &s
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackfill(t *testing.T) {
+	responses := map[string]string{
+		"": `{"per_machine": [{"machine_id": 1, "gpu_earn": 3}, {"machine_id": 2, "gpu_earn": 1}],
+			"per_day": [{"day": 19627, "gpu_earn": 2.5, "sto_earn": 0.5}, {"day": 19626, "gpu_earn": 1.5}]}`,
+		"1": `{"per_day": [{"day": 19626, "gpu_earn": 1, "bwu_earn": 0.25}, {"day": 19627, "gpu_earn": 2}]}`,
+		"2": `{"per_day": [{"day": 19627, "gpu_earn": 0.5, "sto_earn": 0.5}]}`,
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if r.URL.Path != "/users/me/machine-earnings" || query.Get("sday") != "19626" || query.Get("eday") != "19627" {
+			t.Errorf("unexpected request %s", r.URL)
+		}
+		w.Write([]byte(responses[query.Get("machid")]))
+	}))
+	t.Cleanup(server.Close)
+
+	var got bytes.Buffer
+	accounts := []Account{{Name: "test", Client: newTestClient(t, server)}}
+	if err := backfill(context.Background(), accounts, 19626, 19627, &got); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join("testdata", "backfill.om")
+	if *update {
+		if err := ioutil.WriteFile(file, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := ioutil.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.String() != string(want) {
+		t.Errorf("backfill output differs from %s, got:\n%s", file, got.String())
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// accountsConfig is the format of the --accounts-file, e.g.:
+//
+//	accounts:
+//	  - name: team-a
+//	    api_key_file: /run/secrets/team-a
+//	  - name: team-b
+//	    api_key: 0123456789abcdef
+type accountsConfig struct {
+	Accounts []accountConfig `yaml:"accounts"`
+}
+
+type accountConfig struct {
+	Name       string `yaml:"name"`
+	APIKey     string `yaml:"api_key"`
+	APIKeyFile string `yaml:"api_key_file"`
+}
+
+func loadAccountsConfig(path string) ([]accountConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config accountsConfig
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(config.Accounts) == 0 {
+		return nil, fmt.Errorf("no accounts in %s", path)
+	}
+
+	names := make(map[string]bool, len(config.Accounts))
+	for i, account := range config.Accounts {
+		if account.Name == "" {
+			return nil, fmt.Errorf("account #%d in %s has no name", i+1, path)
+		}
+		if names[account.Name] {
+			return nil, fmt.Errorf("duplicate account %q in %s", account.Name, path)
+		}
+		names[account.Name] = true
+		if (account.APIKey == "") == (account.APIKeyFile == "") {
+			return nil, fmt.Errorf("account %q in %s: exactly one of api_key and api_key_file must be set", account.Name, path)
+		}
+	}
+	return config.Accounts, nil
+}
+
+// notifyConfig is the format of the --notify-config file, e.g.:
+//
+//	reliability_drop: 0.01
+//	dedup_window: 6h
+//	max_per_hour: 20
+//	backends:
+//	  - type: slack
+//	    url: https://hooks.slack.com/services/...
+//	  - type: webhook
+//	    url: https://alerts.example.com/vastai
+//	  - type: smtp
+//	    address: smtp.example.com:587
+//	    username: exporter
+//	    password: secret
+//	    from: exporter@example.com
+//	    to: [ops@example.com]
+type notifyConfig struct {
+	// ReliabilityDrop is how much the reliability of a machine has to drop
+	// below the last one notified about, or the highest since, to notify
+	// about it.
+	ReliabilityDrop float64 `yaml:"reliability_drop"`
+	// DedupWindow is how long an identical notification is not sent again.
+	DedupWindow time.Duration `yaml:"dedup_window"`
+	// MaxPerHour limits the number of notifications sent in any hour.
+	MaxPerHour int                   `yaml:"max_per_hour"`
+	Backends   []notifyBackendConfig `yaml:"backends"`
+}
+
+type notifyBackendConfig struct {
+	// Type is webhook, slack or smtp.
+	Type string `yaml:"type"`
+	// URL is used by the webhook and slack backends.
+	URL string `yaml:"url"`
+	// The others are used by the smtp backend.
+	Address  string   `yaml:"address"`
+	Username string   `yaml:"username"`
+	Password string   `yaml:"password"`
+	From     string   `yaml:"from"`
+	To       []string `yaml:"to"`
+}
+
+func loadNotifyConfig(path string) (notifyConfig, error) {
+	config := notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     6 * time.Hour,
+		MaxPerHour:      20,
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	if err := yaml.UnmarshalStrict(data, &config); err != nil {
+		return config, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	if len(config.Backends) == 0 {
+		return config, fmt.Errorf("no backends in %s", path)
+	}
+	if config.ReliabilityDrop <= 0 || config.DedupWindow < 0 || config.MaxPerHour <= 0 {
+		return config, fmt.Errorf("%s: reliability_drop and max_per_hour must be positive and dedup_window not negative", path)
+	}
+	for i, backend := range config.Backends {
+		switch backend.Type {
+		case "webhook", "slack":
+			if backend.URL == "" {
+				return config, fmt.Errorf("backend #%d in %s: url must be set", i+1, path)
+			}
+		case "smtp":
+			if backend.Address == "" || backend.From == "" || len(backend.To) == 0 {
+				return config, fmt.Errorf("backend #%d in %s: address, from and to must be set", i+1, path)
+			}
+		default:
+			return config, fmt.Errorf("backend #%d in %s: unknown type %q", i+1, path, backend.Type)
+		}
+	}
+	return config, nil
+}
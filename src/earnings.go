@@ -0,0 +1,228 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// defaultEarningsWindows are the earnings windows exported unless
+// configured otherwise.
+const defaultEarningsWindows = "today,7d,30d,month_to_date,previous_month"
+
+// earningsWindow is a named range of days that earnings are fetched and
+// exported for, with the name as the window label.
+type earningsWindow struct {
+	name string
+	// days returns the first and last day of the window at t, both
+	// inclusive, in the day numbering of the earnings API.
+	days func(t time.Time) (start, end int)
+}
+
+// parseEarningsWindows parses a comma-separated list of window names.
+func parseEarningsWindows(spec string) ([]earningsWindow, error) {
+	var windows []earningsWindow
+	seen := make(map[string]bool)
+	for _, name := range strings.Split(spec, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("earnings window %q given twice", name)
+		}
+		seen[name] = true
+		window, err := newEarningsWindow(name)
+		if err != nil {
+			return nil, err
+		}
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// newEarningsWindow returns the window called name. Days are in UTC, like
+// the day numbers of the earnings API.
+func newEarningsWindow(name string) (earningsWindow, error) {
+	var days func(t time.Time) (int, int)
+	switch name {
+	case "today":
+		days = func(t time.Time) (int, int) {
+			return dayNumber(t), dayNumber(t)
+		}
+	case "yesterday":
+		days = func(t time.Time) (int, int) {
+			return dayNumber(t) - 1, dayNumber(t) - 1
+		}
+	case "month_to_date":
+		days = func(t time.Time) (int, int) {
+			t = t.UTC()
+			return dayNumber(time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)), dayNumber(t)
+		}
+	case "previous_month":
+		days = func(t time.Time) (int, int) {
+			t = t.UTC()
+			month := time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+			return dayNumber(month.AddDate(0, -1, 0)), dayNumber(month) - 1
+		}
+	case "year_to_date":
+		days = func(t time.Time) (int, int) {
+			t = t.UTC()
+			return dayNumber(time.Date(t.Year(), 1, 1, 0, 0, 0, 0, time.UTC)), dayNumber(t)
+		}
+	default:
+		// The last n days, including today.
+		n, err := strconv.Atoi(strings.TrimSuffix(name, "d"))
+		if !strings.HasSuffix(name, "d") || err != nil || n <= 0 {
+			return earningsWindow{}, fmt.Errorf("unknown earnings window %q, want today, yesterday, month_to_date, previous_month, year_to_date or a number of days such as 7d", name)
+		}
+		days = func(t time.Time) (int, int) {
+			return dayNumber(t) - n + 1, dayNumber(t)
+		}
+	}
+	return earningsWindow{name: name, days: days}, nil
+}
+
+// windowEndpoint is the endpoint label of the earnings fetch of a window.
+func windowEndpoint(window earningsWindow) string {
+	return endpointMachineEarnings + "_" + window.name
+}
+
+// cachedWindow is the earnings of a window that had ended when they were
+// fetched.
+type cachedWindow struct {
+	// fetched is the day the earnings were fetched on.
+	fetched    int
+	start, end int
+	earnings   *vastai.MachineEarningsAPI
+	result     fetchResult
+}
+
+// fetchWindow fetches the earnings of window into s. The earnings of
+// windows that have ended, such as previous_month, are fetched once a day
+// only, in case Vast.ai still corrects them.
+func (a *accountState) fetchWindow(ctx context.Context, s *snapshot, window earningsWindow) {
+	today := dayNumber(s.time)
+	start, end := window.days(s.time)
+	if cached, ok := a.windowCache[window.name]; ok && cached.fetched == today && cached.start == start && cached.end == end {
+		s.windowEarnings[window.name] = cached.earnings
+		s.results[windowEndpoint(window)] = cached.result
+		return
+	}
+	result := a.timeFetch(windowEndpoint(window), func() error {
+		earnings, err := a.client.MachineEarningsBetween(ctx, vastai.EarningsQuery{StartDay: start, EndDay: end})
+		if err == nil {
+			s.windowEarnings[window.name] = earnings
+		}
+		return err
+	})
+	s.results[windowEndpoint(window)] = result
+	if result.err == nil && s.freshEarnings == nil {
+		s.freshEarnings = s.windowEarnings[window.name]
+	}
+	if result.err == nil && end < today {
+		if a.windowCache == nil {
+			a.windowCache = make(map[string]cachedWindow)
+		}
+		a.windowCache[window.name] = cachedWindow{
+			fetched:  today,
+			start:    start,
+			end:      end,
+			earnings: s.windowEarnings[window.name],
+			result:   result,
+		}
+	}
+}
+
+// machineMetadata is what the earnings of a machine are labelled with
+// besides its ID.
+type machineMetadata struct {
+	hostname string
+	gpuName  string
+	listed   bool
+	// retired is whether the machine was missing from the latest
+	// successful machines response. It only labels earnings, so a machine
+	// is only reported as retired while it still has earnings.
+	retired bool
+}
+
+// machineDirectory remembers every machine of an account seen since the
+// exporter started, so that earnings of machines that were removed can
+// still be labelled.
+type machineDirectory struct {
+	machines map[int]machineMetadata
+}
+
+// update marks the machines of a successful machines response as current
+// and all others as retired, and returns a copy of the result.
+func (d *machineDirectory) update(machines []vastai.Machine) map[int]machineMetadata {
+	if d.machines == nil {
+		d.machines = make(map[int]machineMetadata)
+	}
+	for id, machine := range d.machines {
+		machine.retired = true
+		d.machines[id] = machine
+	}
+	for _, machine := range machines {
+		d.machines[machine.MachineID] = machineMetadata{hostname: machine.Hostname, gpuName: machine.GpuName, listed: machine.Listed}
+	}
+	return d.current()
+}
+
+// current returns a copy of the known machines, or nil before the first
+// successful update.
+func (d *machineDirectory) current() map[int]machineMetadata {
+	if d.machines == nil {
+		return nil
+	}
+	result := make(map[int]machineMetadata, len(d.machines))
+	for id, machine := range d.machines {
+		result[id] = machine
+	}
+	return result
+}
+
+// collectEarningsWindows emits the earnings of all machines and of each
+// machine in every window that was fetched successfully. Current listed
+// machines without earnings in a window get zero earnings, and machines
+// with earnings that are not current are labelled as retired.
+func (c *VastCollector) collectEarningsWindows(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	for _, window := range c.earningsWindows {
+		earnings, ok := s.windowEarnings[window.name]
+		if !ok {
+			continue
+		}
+		summary := earnings.Summary
+		collectEarnings(ch, c.metrics["earnings"], summary.TotalGpu, summary.TotalStor, summary.TotalBwu, summary.TotalBwd,
+			account, window.name)
+
+		earned := make(map[int]bool, len(earnings.PerMachine))
+		for _, machine := range earnings.PerMachine {
+			earned[machine.MachineID] = true
+			collectEarnings(ch, c.metrics["machine_earnings"], machine.GpuEarn, machine.StoEarn, machine.BwuEarn, machine.BwdEarn,
+				machineEarningsLabels(account, machine.MachineID, s.machineMetadata, window.name)...)
+		}
+		for id, metadata := range s.machineMetadata {
+			if !metadata.retired && metadata.listed && !earned[id] {
+				collectEarnings(ch, c.metrics["machine_earnings"], 0, 0, 0, 0,
+					machineEarningsLabels(account, id, s.machineMetadata, window.name)...)
+			}
+		}
+	}
+}
+
+// machineEarningsLabels returns the labels of the earnings of a machine,
+// without the type. Machines never seen in a machines response have an
+// empty hostname and GPU name, and are only considered retired once the
+// machines endpoint succeeded.
+func machineEarningsLabels(account string, machineID int, metadata map[int]machineMetadata, window string) []string {
+	machine, ok := metadata[machineID]
+	retired := machine.retired || !ok && metadata != nil
+	return []string{account, strconv.Itoa(machineID), machine.hostname, machine.gpuName, strconv.FormatBool(retired), window}
+}
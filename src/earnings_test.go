@@ -0,0 +1,204 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// testEarningsWindows are the earnings windows of the collectors in tests.
+var testEarningsWindows = mustParseEarningsWindows("today,previous_month")
+
+func mustParseEarningsWindows(spec string) []earningsWindow {
+	windows, err := parseEarningsWindows(spec)
+	if err != nil {
+		panic(err)
+	}
+	return windows
+}
+
+func TestEarningsWindowDays(t *testing.T) {
+	// testTime is 2023-09-29 16:01 UTC, day 19629.
+	for _, test := range []struct {
+		name       string
+		at         time.Time
+		start, end string
+	}{
+		{"today", testTime, "2023-09-29", "2023-09-29"},
+		{"yesterday", testTime, "2023-09-28", "2023-09-28"},
+		{"7d", testTime, "2023-09-23", "2023-09-29"},
+		{"1d", testTime, "2023-09-29", "2023-09-29"},
+		{"month_to_date", testTime, "2023-09-01", "2023-09-29"},
+		{"previous_month", testTime, "2023-08-01", "2023-08-31"},
+		{"previous_month", time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC), "2023-12-01", "2023-12-31"},
+		{"previous_month", time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC), "2024-02-01", "2024-02-29"},
+		{"year_to_date", testTime, "2023-01-01", "2023-09-29"},
+		// Days are in UTC whatever the local time zone.
+		{"today", time.Date(2023, 9, 30, 1, 0, 0, 0, time.FixedZone("CEST", 2*60*60)), "2023-09-29", "2023-09-29"},
+	} {
+		window, err := newEarningsWindow(test.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		start, end := window.days(test.at)
+		if formatDay(start) != test.start || formatDay(end) != test.end {
+			t.Errorf("%s at %s = %s to %s, want %s to %s", test.name, test.at, formatDay(start), formatDay(end), test.start, test.end)
+		}
+	}
+}
+
+func TestParseEarningsWindows(t *testing.T) {
+	windows, err := parseEarningsWindows(defaultEarningsWindows)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(windows) != 5 {
+		t.Errorf("got %d default windows, want 5", len(windows))
+	}
+	if windows, err := parseEarningsWindows(""); err != nil || len(windows) != 0 {
+		t.Errorf("parseEarningsWindows(\"\") = %v, %v, want no windows", windows, err)
+	}
+	for _, spec := range []string{"today,today", "week", "0d", "-7d", "d", "7"} {
+		if _, err := parseEarningsWindows(spec); err == nil {
+			t.Errorf("parseEarningsWindows(%q): expected an error", spec)
+		}
+	}
+}
+
+func TestEarningsWindowsQueries(t *testing.T) {
+	var mu sync.Mutex
+	queries := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/me/machine-earnings" {
+			mu.Lock()
+			queries[r.URL.RawQuery]++
+			mu.Unlock()
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}},
+		CollectorOptions{EarningsWindows: testEarningsWindows})
+	collector.refresh()
+
+	// Today and August 2023.
+	for _, query := range []string{"eday=19629&sday=19629", "eday=19600&sday=19570"} {
+		if queries[query] != 1 {
+			t.Errorf("earnings not fetched with query %q, got %v", query, queries)
+		}
+	}
+	// The period Vast.ai reports by default is only needed for legacy names
+	// and daily earnings.
+	if n := queries[""]; n != 0 {
+		t.Errorf("earnings fetched without a range %d times, want 0", n)
+	}
+	for _, opts := range []CollectorOptions{
+		{EarningsWindows: testEarningsWindows, LegacyNames: true},
+		{EarningsWindows: testEarningsWindows, DailyEarnings: true},
+		{},
+	} {
+		queries[""] = 0
+		NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}}, opts).refresh()
+		if n := queries[""]; n != 1 {
+			t.Errorf("with %+v earnings fetched without a range %d times, want 1", opts, n)
+		}
+	}
+}
+
+func TestClosedEarningsWindowsAreCached(t *testing.T) {
+	var mu sync.Mutex
+	queries := make(map[string]int)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/users/me/machine-earnings" {
+			mu.Lock()
+			queries[r.URL.RawQuery]++
+			mu.Unlock()
+		}
+		w.Write([]byte(`{}`))
+	}))
+	t.Cleanup(server.Close)
+	defer func() { now = func() time.Time { return testTime } }()
+
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}},
+		CollectorOptions{EarningsWindows: testEarningsWindows})
+	collector.refresh()
+	now = func() time.Time { return testTime.Add(time.Minute) }
+	collector.refresh()
+
+	if n := queries["eday=19629&sday=19629"]; n != 2 {
+		t.Errorf("today fetched %d times in two refreshes, want 2", n)
+	}
+	if n := queries["eday=19600&sday=19570"]; n != 1 {
+		t.Errorf("previous month fetched %d times in two refreshes on the same day, want 1", n)
+	}
+	s, _ := collector.accounts[0].current()
+	if _, ok := s.windowEarnings["previous_month"]; !ok || s.results[windowEndpoint(testEarningsWindows[1])].err != nil {
+		t.Error("cached earnings of the previous month missing from the second snapshot")
+	}
+
+	// The next day it is fetched again.
+	now = func() time.Time { return testTime.Add(24 * time.Hour) }
+	collector.refresh()
+	if n := queries["eday=19600&sday=19570"]; n != 2 {
+		t.Errorf("previous month fetched %d times after the day changed, want 2", n)
+	}
+}
+
+func TestMachineEarningsJoin(t *testing.T) {
+	machinesOK := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/machines/":
+			if !machinesOK {
+				http.Error(w, "bad gateway", http.StatusBadGateway)
+				return
+			}
+			w.Write([]byte(`{"machines": [
+				{"machine_id": 1, "hostname": "rig-a", "gpu_name": "RTX 4090", "listed": true},
+				{"machine_id": 2, "hostname": "rig-b", "gpu_name": "RTX 3090", "listed": true},
+				{"machine_id": 4, "hostname": "rig-d", "gpu_name": "RTX 3090", "listed": false}]}`))
+		case "/users/me/machine-earnings":
+			// Machine 2 earned nothing, machine 3 has been removed and
+			// machine 4 is not listed, so it is not expected to earn.
+			w.Write([]byte(`{"per_machine": [
+				{"machine_id": 1, "gpu_earn": 10},
+				{"machine_id": 3, "gpu_earn": 5}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}},
+		CollectorOptions{EarningsWindows: mustParseEarningsWindows("today")})
+	expected := `
+# HELP vastai_machine_earnings_usd Earnings of a machine in the window, by type; retired machines are no longer in the machines response
+# TYPE vastai_machine_earnings_usd gauge
+vastai_machine_earnings_usd{account="test",gpu_name="",hostname="",machine_id="3",retired="true",type="download",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="",hostname="",machine_id="3",retired="true",type="gpu",window="today"} 5
+vastai_machine_earnings_usd{account="test",gpu_name="",hostname="",machine_id="3",retired="true",type="storage",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="",hostname="",machine_id="3",retired="true",type="upload",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 3090",hostname="rig-b",machine_id="2",retired="false",type="download",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 3090",hostname="rig-b",machine_id="2",retired="false",type="gpu",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 3090",hostname="rig-b",machine_id="2",retired="false",type="storage",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 3090",hostname="rig-b",machine_id="2",retired="false",type="upload",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 4090",hostname="rig-a",machine_id="1",retired="false",type="download",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 4090",hostname="rig-a",machine_id="1",retired="false",type="gpu",window="today"} 10
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 4090",hostname="rig-a",machine_id="1",retired="false",type="storage",window="today"} 0
+vastai_machine_earnings_usd{account="test",gpu_name="RTX 4090",hostname="rig-a",machine_id="1",retired="false",type="upload",window="today"} 0
+`
+	// A failed machines fetch keeps the labels of the previous refresh.
+	for _, ok := range []bool{true, false} {
+		machinesOK = ok
+		collector.refresh()
+		if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "vastai_machine_earnings_usd"); err != nil {
+			t.Errorf("machines endpoint ok = %v: %s", ok, err)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// windowHours returns the number of hours of window that have passed at t.
+func windowHours(window earningsWindow, t time.Time) float64 {
+	start, end := window.days(t)
+	from := time.Unix(int64(start)*24*60*60, 0)
+	to := time.Unix(int64(end+1)*24*60*60, 0)
+	if t.Before(to) {
+		to = t
+	}
+	return to.Sub(from).Hours()
+}
+
+// gpuModelTotals sums what the efficiency of a GPU model is derived from.
+type gpuModelTotals struct {
+	earnings map[string]float64 // by window
+	gpuHours map[string]float64 // by window
+	idleCost float64
+	// dlperfEarnings and dlperf only count machines with a DLPerf score.
+	dlperfEarnings map[string]float64 // by window
+	dlperf         float64
+}
+
+// collectEfficiency emits revenue efficiency metrics derived from the
+// machines and the earnings windows of s, per machine and per GPU model.
+// Earnings are summed over all earning types.
+func (c *VastCollector) collectEfficiency(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	models := make(map[string]*gpuModelTotals)
+	for _, machine := range s.machines.Machines {
+		labels := []string{account, strconv.Itoa(machine.MachineID), machine.Hostname}
+		model, ok := models[machine.GpuName]
+		if !ok {
+			model = &gpuModelTotals{
+				earnings:       make(map[string]float64),
+				gpuHours:       make(map[string]float64),
+				dlperfEarnings: make(map[string]float64),
+			}
+			models[machine.GpuName] = model
+		}
+		model.dlperf += machine.Dlperf
+
+		counts := countStates(parseOccupancy(machine.GpuOccupancy, machine.NumGpus))
+		rented := counts[gpuReserved] + counts[gpuOnDemand] + counts[gpuInterruptible]
+		if rented > 0 {
+			realised := machine.EarnHour / float64(rented)
+			ch <- prometheus.MustNewConstMetric(c.metrics["machine_realised_gpu_price"], prometheus.GaugeValue, realised, labels...)
+			if machine.ListedGpuCost > 0 {
+				ch <- prometheus.MustNewConstMetric(c.metrics["machine_realised_price_ratio"], prometheus.GaugeValue, realised/machine.ListedGpuCost, labels...)
+			}
+		}
+		idleCost := float64(counts[gpuIdle]) * machine.ListedGpuCost
+		model.idleCost += idleCost
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_idle_opportunity_cost"], prometheus.GaugeValue, idleCost, labels...)
+
+		for _, window := range c.earningsWindows {
+			earnings, ok := s.windowEarnings[window.name]
+			if !ok || machine.NumGpus == 0 {
+				continue
+			}
+			total := machineTotalEarnings(earnings, machine.MachineID)
+			gpuHours := float64(machine.NumGpus) * windowHours(window, s.time)
+			model.earnings[window.name] += total
+			model.gpuHours[window.name] += gpuHours
+
+			windowLabels := append(labels, window.name)
+			ch <- prometheus.MustNewConstMetric(c.metrics["machine_earnings_per_gpu"], prometheus.GaugeValue, total/float64(machine.NumGpus), windowLabels...)
+			if gpuHours > 0 {
+				ch <- prometheus.MustNewConstMetric(c.metrics["machine_earnings_per_gpu_hour"], prometheus.GaugeValue, total/gpuHours, windowLabels...)
+			}
+			if machine.TotalFlops > 0 {
+				ch <- prometheus.MustNewConstMetric(c.metrics["machine_earnings_per_tflops"], prometheus.GaugeValue, total/machine.TotalFlops, windowLabels...)
+			}
+			if machine.Dlperf > 0 {
+				model.dlperfEarnings[window.name] += total
+				ch <- prometheus.MustNewConstMetric(c.metrics["machine_earnings_per_dlperf"], prometheus.GaugeValue, total/machine.Dlperf, windowLabels...)
+			}
+		}
+	}
+
+	for gpuName, model := range models {
+		ch <- prometheus.MustNewConstMetric(c.metrics["gpu_model_idle_opportunity_cost"], prometheus.GaugeValue, model.idleCost, account, gpuName)
+		for window, gpuHours := range model.gpuHours {
+			if gpuHours > 0 {
+				ch <- prometheus.MustNewConstMetric(c.metrics["gpu_model_earnings_per_gpu_hour"], prometheus.GaugeValue, model.earnings[window]/gpuHours, account, gpuName, window)
+			}
+		}
+		if model.dlperf > 0 {
+			for window, earnings := range model.dlperfEarnings {
+				ch <- prometheus.MustNewConstMetric(c.metrics["gpu_model_earnings_per_dlperf"], prometheus.GaugeValue, earnings/model.dlperf, account, gpuName, window)
+			}
+		}
+	}
+}
+
+// machineTotalEarnings returns the earnings of all types of a machine, 0
+// if it earned nothing.
+func machineTotalEarnings(earnings *vastai.MachineEarningsAPI, machineID int) float64 {
+	for _, machine := range earnings.PerMachine {
+		if machine.MachineID == machineID {
+			return machine.GpuEarn + machine.StoEarn + machine.BwuEarn + machine.BwdEarn
+		}
+	}
+	return 0
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestWindowHours(t *testing.T) {
+	// testTime is 2023-09-29 16:01 UTC.
+	for _, test := range []struct {
+		window string
+		want   float64
+	}{
+		{"today", 16 + 1.0/60},
+		{"yesterday", 24},
+		{"7d", 6*24 + 16 + 1.0/60},
+		{"previous_month", 31 * 24},
+	} {
+		window, err := newEarningsWindow(test.window)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := windowHours(window, testTime); got != test.want {
+			t.Errorf("windowHours(%s) = %v, want %v", test.window, got, test.want)
+		}
+	}
+
+	// At midnight nothing of today has passed yet.
+	today, _ := newEarningsWindow("today")
+	if got := windowHours(today, time.Date(2023, 9, 29, 0, 0, 0, 0, time.UTC)); got != 0 {
+		t.Errorf("windowHours(today) at midnight = %v, want 0", got)
+	}
+}
+
+func TestEarningsPerDLPerf(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/machines/":
+			// Machine 3 has no DLPerf score and is left out, also of the
+			// sum of its GPU model.
+			w.Write([]byte(`{"machines": [
+				{"machine_id": 1, "hostname": "rig-a", "gpu_name": "RTX 4090", "num_gpus": 1, "dlperf": 100},
+				{"machine_id": 2, "hostname": "rig-b", "gpu_name": "RTX 4090", "num_gpus": 1, "dlperf": 300},
+				{"machine_id": 3, "hostname": "rig-c", "gpu_name": "RTX 4090", "num_gpus": 1}]}`))
+		case "/users/me/machine-earnings":
+			w.Write([]byte(`{"per_machine": [
+				{"machine_id": 1, "gpu_earn": 10, "sto_earn": 2},
+				{"machine_id": 2, "gpu_earn": 20},
+				{"machine_id": 3, "gpu_earn": 50}]}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}},
+		CollectorOptions{EarningsWindows: mustParseEarningsWindows("today")})
+	collector.refresh()
+	expected := `
+# HELP vastai_machine_earnings_per_dlperf_usd Earnings of all types of the machine in the window per DLPerf point of the machine
+# TYPE vastai_machine_earnings_per_dlperf_usd gauge
+vastai_machine_earnings_per_dlperf_usd{account="test",hostname="rig-a",machine_id="1",window="today"} 0.12
+vastai_machine_earnings_per_dlperf_usd{account="test",hostname="rig-b",machine_id="2",window="today"} 0.06666666666666667
+# HELP vastai_gpu_model_earnings_per_dlperf_usd Earnings of all types of the machines with the GPU model in the window per DLPerf point of those machines, counting only machines with a DLPerf score
+# TYPE vastai_gpu_model_earnings_per_dlperf_usd gauge
+vastai_gpu_model_earnings_per_dlperf_usd{account="test",gpu_name="RTX 4090",window="today"} 0.08
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"vastai_machine_earnings_per_dlperf_usd", "vastai_gpu_model_earnings_per_dlperf_usd"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// rentalEventLogSize is the number of events kept for the event log.
+const rentalEventLogSize = 1000
+
+// Kinds of rental events.
+const (
+	eventRentalStarted   = "rental_started"
+	eventRentalEnded     = "rental_ended"
+	eventRentalExtended  = "rental_extended"
+	eventRentalRetyped   = "rental_type_changed"
+	eventGpuStateChanged = "gpu_state_changed"
+)
+
+// rentalEvent is a change detected between two refreshes of a machine.
+type rentalEvent struct {
+	Time      time.Time `json:"time"`
+	Account   string    `json:"account"`
+	MachineID int       `json:"machine_id"`
+	Hostname  string    `json:"hostname"`
+	Kind      string    `json:"kind"`
+	// Set for rental events.
+	ContractID int     `json:"contract_id,omitempty"`
+	Type       string  `json:"type,omitempty"`
+	EndDate    float64 `json:"end_date,omitempty"`
+	// Set for gpu_state_changed events.
+	GPU *int `json:"gpu,omitempty"`
+	// Previous and new type of a rental, or state of a GPU.
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// rentalCounterKey identifies a rental event counter of an account.
+type rentalCounterKey struct {
+	kind      string
+	machineID int
+	// rentalType is set for started, ended and extended rentals, from and
+	// to for changes.
+	rentalType string
+	from, to   string
+}
+
+// machineRentals is what events are detected from on a machine.
+type machineRentals struct {
+	clients map[int]vastai.MachineClient
+	gpus    []gpuState
+}
+
+// rentalEvents detects rental events by comparing each refresh of the
+// machines of an account with the previous one, and keeps a log of the
+// most recent events of all accounts.
+type rentalEvents struct {
+	mu       sync.Mutex
+	previous map[string]map[int]machineRentals
+	counters map[string]map[rentalCounterKey]float64
+	log      []rentalEvent
+}
+
+func newRentalEvents() *rentalEvents {
+	return &rentalEvents{
+		previous: make(map[string]map[int]machineRentals),
+		counters: make(map[string]map[rentalCounterKey]float64),
+	}
+}
+
+// observe compares machines with the previous refresh of account and
+// returns a copy of the event counters of the account. Machines seen for
+// the first time only become the baseline, so that a restart of the
+// exporter or a machine coming back online does not look like new rentals.
+func (e *rentalEvents) observe(account string, at time.Time, machines []vastai.Machine) map[rentalCounterKey]float64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	counters, ok := e.counters[account]
+	if !ok {
+		counters = make(map[rentalCounterKey]float64)
+		e.counters[account] = counters
+	}
+	previous := e.previous[account]
+	current := make(map[int]machineRentals, len(machines))
+	var events []rentalEvent
+	for _, machine := range machines {
+		rentals := machineRentals{
+			clients: make(map[int]vastai.MachineClient, len(machine.Clients)),
+			gpus:    parseOccupancy(machine.GpuOccupancy, machine.NumGpus),
+		}
+		for _, client := range machine.Clients {
+			rentals.clients[client.ID] = client
+		}
+		current[machine.MachineID] = rentals
+
+		if before, ok := previous[machine.MachineID]; ok {
+			for _, event := range diffRentals(before, rentals) {
+				event.Time = at
+				event.Account = account
+				event.MachineID = machine.MachineID
+				event.Hostname = machine.Hostname
+				events = append(events, event)
+			}
+		}
+	}
+	// The log lists events in a stable order, whatever the order of the
+	// response and of the maps they are diffed from.
+	sort.SliceStable(events, func(i, j int) bool {
+		if events[i].MachineID != events[j].MachineID {
+			return events[i].MachineID < events[j].MachineID
+		}
+		return events[i].ContractID < events[j].ContractID
+	})
+	for _, event := range events {
+		e.record(counters, event)
+	}
+	// Machines missing from this refresh keep their previous state, so
+	// that their rentals are not reported as ended while they are offline.
+	for machineID, rentals := range previous {
+		if _, ok := current[machineID]; !ok {
+			current[machineID] = rentals
+		}
+	}
+	e.previous[account] = current
+
+	result := make(map[rentalCounterKey]float64, len(counters))
+	for key, value := range counters {
+		result[key] = value
+	}
+	return result
+}
+
+// diffRentals returns the events between two refreshes of a machine,
+// without the fields common to all events of the machine.
+func diffRentals(before, after machineRentals) []rentalEvent {
+	var events []rentalEvent
+	for id, client := range after.clients {
+		previous, ok := before.clients[id]
+		switch {
+		case !ok:
+			events = append(events, rentalEvent{Kind: eventRentalStarted, ContractID: id, Type: client.Type, EndDate: client.EndDate})
+		case previous.Type != client.Type:
+			events = append(events, rentalEvent{Kind: eventRentalRetyped, ContractID: id, Type: client.Type, From: previous.Type, To: client.Type})
+		}
+		if ok && previous.EndDate > 0 && client.EndDate > previous.EndDate {
+			events = append(events, rentalEvent{Kind: eventRentalExtended, ContractID: id, Type: client.Type, EndDate: client.EndDate})
+		}
+	}
+	for id, client := range before.clients {
+		if _, ok := after.clients[id]; !ok {
+			events = append(events, rentalEvent{Kind: eventRentalEnded, ContractID: id, Type: client.Type, EndDate: client.EndDate})
+		}
+	}
+	for i := 0; i < len(before.gpus) && i < len(after.gpus); i++ {
+		from, to := before.gpus[i], after.gpus[i]
+		if from == to || from == gpuUnknown || to == gpuUnknown {
+			continue
+		}
+		gpu := i
+		events = append(events, rentalEvent{Kind: eventGpuStateChanged, GPU: &gpu, From: from.String(), To: to.String()})
+	}
+	return events
+}
+
+func (e *rentalEvents) record(counters map[rentalCounterKey]float64, event rentalEvent) {
+	key := rentalCounterKey{kind: event.Kind, machineID: event.MachineID}
+	switch event.Kind {
+	case eventRentalStarted, eventRentalEnded, eventRentalExtended:
+		key.rentalType = event.Type
+		log.Printf("Rental %d of type %s on machine %d (%s) of account %s: %s",
+			event.ContractID, event.Type, event.MachineID, event.Hostname, event.Account, event.Kind)
+	default:
+		key.from, key.to = event.From, event.To
+	}
+	counters[key]++
+
+	e.log = append(e.log, event)
+	if len(e.log) > rentalEventLogSize {
+		e.log = append([]rentalEvent(nil), e.log[len(e.log)-rentalEventLogSize:]...)
+	}
+}
+
+// ServeHTTP lists the most recent events as JSON, newest first. The
+// number of events can be limited with the limit parameter.
+func (e *rentalEvents) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	limit := rentalEventLogSize
+	if value := r.URL.Query().Get("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	e.mu.Lock()
+	events := make([]rentalEvent, 0, len(e.log))
+	for i := len(e.log) - 1; i >= 0 && len(events) < limit; i-- {
+		events = append(events, e.log[i])
+	}
+	e.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Events []rentalEvent `json:"events"`
+	}{events})
+}
+
+// collectRentalEvents emits the rental event counters of s.
+func (c *VastCollector) collectRentalEvents(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	for key, value := range s.rentalEvents {
+		machineID := strconv.Itoa(key.machineID)
+		switch key.kind {
+		case eventRentalStarted:
+			ch <- prometheus.MustNewConstMetric(c.metrics["rentals_started"], prometheus.CounterValue, value, account, machineID, key.rentalType)
+		case eventRentalEnded:
+			ch <- prometheus.MustNewConstMetric(c.metrics["rentals_ended"], prometheus.CounterValue, value, account, machineID, key.rentalType)
+		case eventRentalExtended:
+			ch <- prometheus.MustNewConstMetric(c.metrics["rentals_extended"], prometheus.CounterValue, value, account, machineID, key.rentalType)
+		case eventRentalRetyped:
+			ch <- prometheus.MustNewConstMetric(c.metrics["rental_type_changes"], prometheus.CounterValue, value, account, machineID, key.from, key.to)
+		case eventGpuStateChanged:
+			ch <- prometheus.MustNewConstMetric(c.metrics["gpu_state_changes"], prometheus.CounterValue, value, account, machineID, key.from, key.to)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+func TestRentalEvents(t *testing.T) {
+	collector := newLaterFixtureCollector(t, CollectorOptions{})
+	compareWithGolden(t, collector, filepath.Join("testdata", "later", "metrics.prom"))
+}
+
+func TestRentalEventsBaseline(t *testing.T) {
+	events := newRentalEvents()
+	machines := []vastai.Machine{{MachineID: 1, NumGpus: 1, GpuOccupancy: "D", Clients: []vastai.MachineClient{{ID: 10, Type: "ask"}}}}
+	if counters := events.observe("a", testTime, machines); len(counters) != 0 {
+		t.Errorf("first refresh counted %v, want nothing", counters)
+	}
+
+	// The machine drops out of the response and comes back unchanged.
+	events.observe("a", testTime.Add(time.Minute), nil)
+	if counters := events.observe("a", testTime.Add(2*time.Minute), machines); len(counters) != 0 {
+		t.Errorf("machine coming back counted %v, want nothing", counters)
+	}
+
+	// Unknown occupancy is not a state change.
+	machines[0].GpuOccupancy = "?"
+	if counters := events.observe("a", testTime.Add(3*time.Minute), machines); len(counters) != 0 {
+		t.Errorf("unknown occupancy counted %v, want nothing", counters)
+	}
+}
+
+func TestRentalEventsOrder(t *testing.T) {
+	events := newRentalEvents()
+	machine := func(id int, contracts ...int) vastai.Machine {
+		machine := vastai.Machine{MachineID: id}
+		for _, contract := range contracts {
+			machine.Clients = append(machine.Clients, vastai.MachineClient{ID: contract, Type: "ask"})
+		}
+		return machine
+	}
+	events.observe("a", testTime.Add(-time.Minute), []vastai.Machine{machine(2), machine(1)})
+	events.observe("a", testTime, []vastai.Machine{machine(2, 25, 21, 23), machine(1, 14, 12, 13, 11)})
+
+	want := [][2]int{{1, 11}, {1, 12}, {1, 13}, {1, 14}, {2, 21}, {2, 23}, {2, 25}}
+	if len(events.log) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events.log), len(want))
+	}
+	for i, event := range events.log {
+		if got := [2]int{event.MachineID, event.ContractID}; got != want[i] {
+			t.Errorf("event %d is contract %d on machine %d, want %d on %d", i, got[1], got[0], want[i][1], want[i][0])
+		}
+	}
+}
+
+func TestEventLog(t *testing.T) {
+	collector := newLaterFixtureCollector(t, CollectorOptions{})
+
+	recorder := httptest.NewRecorder()
+	collector.Events().ServeHTTP(recorder, httptest.NewRequest("GET", "/events?limit=100", nil))
+	var response struct {
+		Events []rentalEvent `json:"events"`
+	}
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+
+	kinds := make(map[string]int)
+	for _, event := range response.Events {
+		if !event.Time.Equal(testTime) {
+			t.Errorf("event %+v at %s, want %s", event, event.Time, testTime)
+		}
+		kinds[event.Kind]++
+	}
+	want := map[string]int{
+		eventRentalStarted:   2,
+		eventRentalEnded:     1,
+		eventRentalExtended:  1,
+		eventRentalRetyped:   1,
+		eventGpuStateChanged: 3,
+	}
+	for kind, n := range want {
+		if kinds[kind] != n {
+			t.Errorf("got %d %s events, want %d", kinds[kind], kind, n)
+		}
+	}
+
+	recorder = httptest.NewRecorder()
+	collector.Events().ServeHTTP(recorder, httptest.NewRequest("GET", "/events?limit=2", nil))
+	if err := json.NewDecoder(recorder.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Events) != 2 {
+		t.Errorf("got %d events with limit=2", len(response.Events))
+	}
+
+	recorder = httptest.NewRecorder()
+	collector.Events().ServeHTTP(recorder, httptest.NewRequest("GET", "/events?limit=x", nil))
+	if recorder.Code != 400 {
+		t.Errorf("got status %d for an invalid limit, want 400", recorder.Code)
+	}
+}
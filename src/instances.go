@@ -0,0 +1,62 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// instanceCostTracker accumulates the cost of instances from their hourly
+// cost, as the instances endpoint does not report what has been spent.
+type instanceCostTracker struct {
+	time  time.Time
+	rates map[int]float64
+	costs map[int]float64
+}
+
+// update adds the cost of each instance since the previous update, at the
+// hourly rate seen then, and returns a copy of the accumulated costs.
+// Instances that are gone are forgotten.
+func (t *instanceCostTracker) update(now time.Time, instances []vastai.Instance) map[int]float64 {
+	hours := now.Sub(t.time).Hours()
+	rates := make(map[int]float64, len(instances))
+	costs := make(map[int]float64, len(instances))
+	for _, instance := range instances {
+		costs[instance.ID] = t.costs[instance.ID]
+		if rate, ok := t.rates[instance.ID]; ok {
+			costs[instance.ID] += rate * hours
+		}
+		rates[instance.ID] = instance.DphTotal
+	}
+	t.time, t.rates, t.costs = now, rates, costs
+
+	result := make(map[int]float64, len(costs))
+	for id, cost := range costs {
+		result[id] = cost
+	}
+	return result
+}
+
+func (c *VastCollector) collectInstances(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	for _, instance := range s.instances.Instances {
+		labels := []string{account, strconv.Itoa(instance.ID)}
+		rentalType := "on_demand"
+		if instance.IsBid {
+			rentalType = "interruptible"
+		}
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["instance_info"], prometheus.GaugeValue, 1,
+			account, strconv.Itoa(instance.ID), strconv.Itoa(instance.MachineID), instance.GpuName, instance.ImageUUID, rentalType, instance.Label)
+		ch <- prometheus.MustNewConstMetric(c.metrics["instance_status"], prometheus.GaugeValue, 1, append(labels, instance.ActualStatus)...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["instance_gpus"], prometheus.GaugeValue, float64(instance.NumGpus), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["instance_cost_per_hour"], prometheus.GaugeValue, instance.DphTotal, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["instance_cost"], prometheus.CounterValue, s.instanceCosts[instance.ID], labels...)
+		if instance.StartDate > 0 {
+			uptime := float64(s.time.Unix()) - instance.StartDate
+			ch <- prometheus.MustNewConstMetric(c.metrics["instance_uptime"], prometheus.GaugeValue, uptime, labels...)
+		}
+	}
+}
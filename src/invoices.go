@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+const (
+	// invoicePage is the range of time fetched per request when paging
+	// through the invoice history.
+	invoicePage = 180 * 24 * time.Hour
+	// invoiceOverlap is how far before the high-water mark invoices are
+	// fetched again, in case they show up late. Invoices already counted
+	// are recognised and skipped.
+	invoiceOverlap = 24 * time.Hour
+)
+
+// invoiceAccount is the invoice history of one account, as persisted.
+type invoiceAccount struct {
+	// Synced is the high-water mark: the end of the last range of the
+	// history that was fetched completely, as a UNIX timestamp.
+	Synced int64 `json:"synced"`
+	// Seen holds the invoices counted since Synced minus invoiceOverlap,
+	// by invoiceKey, with their timestamps.
+	Seen map[string]int64 `json:"seen"`
+	// Amounts and Counts sum the invoices counted, by invoice type.
+	Amounts map[string]float64 `json:"amounts"`
+	Counts  map[string]float64 `json:"counts"`
+}
+
+func (a *invoiceAccount) copy() *invoiceAccount {
+	result := &invoiceAccount{
+		Synced:  a.Synced,
+		Seen:    make(map[string]int64, len(a.Seen)),
+		Amounts: make(map[string]float64, len(a.Amounts)),
+		Counts:  make(map[string]float64, len(a.Counts)),
+	}
+	for key, value := range a.Seen {
+		result.Seen[key] = value
+	}
+	for key, value := range a.Amounts {
+		result.Amounts[key] = value
+	}
+	for key, value := range a.Counts {
+		result.Counts[key] = value
+	}
+	return result
+}
+
+// invoiceLedger pages through the invoice history of all accounts and
+// sums it into counters, keeping a high-water mark per account so that
+// each invoice is counted once. It optionally persists its state to a
+// file, so that counters survive restarts of the exporter.
+type invoiceLedger struct {
+	path  string
+	start time.Time
+
+	mu       sync.Mutex
+	accounts map[string]*invoiceAccount
+}
+
+func newInvoiceLedger(path string, start time.Time) *invoiceLedger {
+	return &invoiceLedger{path: path, start: start, accounts: make(map[string]*invoiceAccount)}
+}
+
+// parseInvoiceHistoryStart parses the --invoice-history-start flag. It
+// returns the zero time if the invoice counters are disabled.
+func parseInvoiceHistoryStart(start, stateFile string) (time.Time, error) {
+	if start == "" {
+		if stateFile != "" {
+			return time.Time{}, errors.New("--invoice-state-file requires --invoice-history-start")
+		}
+		return time.Time{}, nil
+	}
+	day, err := parseDay(start)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(int64(day)*24*60*60, 0), nil
+}
+
+// invoiceKey identifies an invoice. Invoices without an ID are identified
+// by all of their fields.
+func invoiceKey(invoice vastai.Invoice) string {
+	if invoice.ID != 0 {
+		return fmt.Sprintf("id:%d", invoice.ID)
+	}
+	return fmt.Sprintf("%s/%v/%v/%s", invoice.Type, invoice.Timestamp, invoice.Amount, invoice.Description)
+}
+
+// sync fetches the invoices of account from shortly before its high-water
+// mark up to now, one page at a time, and counts the ones not seen yet.
+// If a page fails, the progress made so far is kept. It returns a copy of
+// the state of the account.
+func (l *invoiceLedger) sync(ctx context.Context, account string, client *vastai.Client, now time.Time) (*invoiceAccount, error) {
+	l.mu.Lock()
+	state, ok := l.accounts[account]
+	if ok {
+		state = state.copy()
+	} else {
+		state = &invoiceAccount{
+			Synced:  l.start.Unix(),
+			Seen:    make(map[string]int64),
+			Amounts: make(map[string]float64),
+			Counts:  make(map[string]float64),
+		}
+	}
+	l.mu.Unlock()
+
+	var err error
+	from := time.Unix(state.Synced, 0).Add(-invoiceOverlap)
+	if from.Before(l.start) {
+		from = l.start
+	}
+	for from.Before(now) {
+		to := from.Add(invoicePage)
+		if to.After(now) {
+			to = now
+		}
+		var invoices *vastai.InvoicesAPI
+		invoices, err = client.Invoices(ctx, from, to)
+		if err != nil {
+			break
+		}
+		for _, invoice := range invoices.Invoices {
+			key := invoiceKey(invoice)
+			if _, ok := state.Seen[key]; ok {
+				continue
+			}
+			state.Seen[key] = int64(invoice.Timestamp)
+			// Counters only go up, whatever the sign the API uses.
+			state.Amounts[invoice.Type] += math.Abs(invoice.Amount)
+			state.Counts[invoice.Type]++
+		}
+		if to.Unix() > state.Synced {
+			state.Synced = to.Unix()
+		}
+		from = to
+	}
+	for key, timestamp := range state.Seen {
+		if timestamp < state.Synced-int64(invoiceOverlap/time.Second) {
+			delete(state.Seen, key)
+		}
+	}
+
+	l.mu.Lock()
+	l.accounts[account] = state
+	l.mu.Unlock()
+	return state.copy(), err
+}
+
+// load reads the state from the file, if there is one.
+func (l *invoiceLedger) load() error {
+	if l.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(l.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var accounts map[string]*invoiceAccount
+	if err := json.Unmarshal(data, &accounts); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", l.path, err)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for name, state := range accounts {
+		// Maps missing from the file would be nil.
+		l.accounts[name] = state.copy()
+	}
+	return nil
+}
+
+// save writes the state to the file.
+func (l *invoiceLedger) save() error {
+	if l.path == "" {
+		return nil
+	}
+	l.mu.Lock()
+	data, err := json.Marshal(l.accounts)
+	l.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(l.path, data)
+}
+
+// collectInvoices emits the invoice counters of s.
+func (c *VastCollector) collectInvoices(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	for invoiceType, amount := range s.invoiceHistory.Amounts {
+		ch <- prometheus.MustNewConstMetric(c.metrics["invoice_amount"], prometheus.CounterValue, amount, account, invoiceType)
+	}
+	for invoiceType, count := range s.invoiceHistory.Counts {
+		ch <- prometheus.MustNewConstMetric(c.metrics["invoices"], prometheus.CounterValue, count, account, invoiceType)
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["invoice_history_synced"], prometheus.GaugeValue, float64(s.invoiceHistory.Synced), account)
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// invoiceServer serves the invoices in the requested range of time and
+// records the ranges requested.
+type invoiceServer struct {
+	invoices []vastai.Invoice
+	ranges   [][2]int64
+	// failAt makes the request with this index fail, if not negative.
+	failAt int
+}
+
+func newInvoiceServer(t *testing.T, invoices []vastai.Invoice) (*invoiceServer, *vastai.Client) {
+	s := &invoiceServer{invoices: invoices, failAt: -1}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start, _ := strconv.ParseInt(r.URL.Query().Get("sdate"), 10, 64)
+		end, _ := strconv.ParseInt(r.URL.Query().Get("edate"), 10, 64)
+		s.ranges = append(s.ranges, [2]int64{start, end})
+		if len(s.ranges)-1 == s.failAt {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		result := vastai.InvoicesAPI{Invoices: []vastai.Invoice{}}
+		for _, invoice := range s.invoices {
+			if int64(invoice.Timestamp) >= start && int64(invoice.Timestamp) <= end {
+				result.Invoices = append(result.Invoices, invoice)
+			}
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	t.Cleanup(server.Close)
+	return s, newTestClient(t, server)
+}
+
+var testInvoices = []vastai.Invoice{
+	{ID: 90311, Type: "charge", Amount: 1.24, Timestamp: 1695945600},
+	{ID: 90288, Type: "payment", Amount: 20, Timestamp: 1695859200},
+	{ID: 90102, Type: "payout", Amount: -301.17, Timestamp: 1695686400},
+	{ID: 89730, Type: "payout", Amount: -287.92, Timestamp: 1695081600},
+	// Without an ID.
+	{Type: "fee", Amount: 0.85, Timestamp: 1695081600, Description: "Payout fee"},
+}
+
+func checkInvoiceTotals(t *testing.T, got *invoiceAccount, amounts, counts map[string]float64) {
+	t.Helper()
+	for invoiceType, amount := range amounts {
+		if got.Amounts[invoiceType] != amount {
+			t.Errorf("amount of %s invoices = %v, want %v", invoiceType, got.Amounts[invoiceType], amount)
+		}
+	}
+	for invoiceType, count := range counts {
+		if got.Counts[invoiceType] != count {
+			t.Errorf("number of %s invoices = %v, want %v", invoiceType, got.Counts[invoiceType], count)
+		}
+	}
+}
+
+func TestInvoiceLedgerCountsEachInvoiceOnce(t *testing.T) {
+	server, client := newInvoiceServer(t, testInvoices)
+	start := testTime.Add(-400 * 24 * time.Hour)
+	ledger := newInvoiceLedger("", start)
+
+	got, err := ledger.sync(context.Background(), "a", client, testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.ranges) != 3 {
+		t.Errorf("paged through 400 days in %d requests, want 3", len(server.ranges))
+	}
+	if first := server.ranges[0][0]; first != start.Unix() {
+		t.Errorf("first page starts at %d, want %d", first, start.Unix())
+	}
+	if got.Synced != testTime.Unix() {
+		t.Errorf("synced up to %d, want %d", got.Synced, testTime.Unix())
+	}
+	checkInvoiceTotals(t, got,
+		map[string]float64{"charge": 1.24, "payment": 20, "payout": 301.17 + 287.92, "fee": 0.85},
+		map[string]float64{"charge": 1, "payment": 1, "payout": 2, "fee": 1})
+
+	// An invoice that shows up late, timestamped before the high-water
+	// mark. Invoices fetched again in the overlap are not counted twice.
+	server.invoices = append(server.invoices, vastai.Invoice{ID: 90400, Type: "charge", Amount: 2, Timestamp: float64(testTime.Add(-time.Hour).Unix())})
+	server.ranges = nil
+	got, err = ledger.sync(context.Background(), "a", client, testTime.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [2]int64{testTime.Add(-invoiceOverlap).Unix(), testTime.Add(time.Hour).Unix()}; len(server.ranges) != 1 || server.ranges[0] != want {
+		t.Errorf("requested %v, want only %v", server.ranges, want)
+	}
+	checkInvoiceTotals(t, got,
+		map[string]float64{"charge": 3.24, "payment": 20},
+		map[string]float64{"charge": 2, "payment": 1})
+}
+
+func TestInvoiceLedgerResumesAfterFailure(t *testing.T) {
+	server, client := newInvoiceServer(t, testInvoices)
+	start := testTime.Add(-400 * 24 * time.Hour)
+	ledger := newInvoiceLedger("", start)
+
+	server.failAt = 2
+	got, err := ledger.sync(context.Background(), "a", client, testTime)
+	if err == nil {
+		t.Fatal("sync succeeded although the last page failed")
+	}
+	if want := start.Add(2 * invoicePage).Unix(); got.Synced != want {
+		t.Errorf("synced up to %d, want the end of the second page %d", got.Synced, want)
+	}
+	if len(got.Counts) != 0 {
+		t.Errorf("counted %v, want nothing before the failed page", got.Counts)
+	}
+
+	server.failAt = -1
+	server.ranges = nil
+	got, err = ledger.sync(context.Background(), "a", client, testTime)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(server.ranges) != 1 {
+		t.Errorf("resumed in %d requests, want 1", len(server.ranges))
+	}
+	checkInvoiceTotals(t, got, nil, map[string]float64{"charge": 1, "payment": 1, "payout": 2, "fee": 1})
+}
+
+func TestInvoiceLedgerPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "invoices.json")
+	_, client := newInvoiceServer(t, testInvoices)
+	start := testTime.Add(-30 * 24 * time.Hour)
+
+	ledger := newInvoiceLedger(path, start)
+	if err := ledger.load(); err != nil {
+		t.Fatalf("loading a missing file: %s", err)
+	}
+	if _, err := ledger.sync(context.Background(), "a", client, testTime); err != nil {
+		t.Fatal(err)
+	}
+	if err := ledger.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newInvoiceLedger(path, start)
+	if err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	got, err := restarted.sync(context.Background(), "a", client, testTime.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkInvoiceTotals(t, got,
+		map[string]float64{"charge": 1.24, "fee": 0.85},
+		map[string]float64{"charge": 1, "payment": 1, "payout": 2, "fee": 1})
+}
+
+func TestParseInvoiceHistoryStart(t *testing.T) {
+	if start, err := parseInvoiceHistoryStart("", ""); err != nil || !start.IsZero() {
+		t.Errorf("without flags got %v, %v, want disabled", start, err)
+	}
+	if start, err := parseInvoiceHistoryStart("2023-01-01", "invoices.json"); err != nil || start.Unix() != 1672531200 {
+		t.Errorf("got %v, %v, want 2023-01-01 UTC", start, err)
+	}
+	if _, err := parseInvoiceHistoryStart("", "invoices.json"); err == nil {
+		t.Error("accepted a state file without a history start")
+	}
+	if _, err := parseInvoiceHistoryStart("January", ""); err == nil {
+		t.Error("accepted an invalid history start")
+	}
+}
+
+func TestCollectorInvoices(t *testing.T) {
+	collector := newFixtureCollector(t, filepath.Join("testdata", "full"), CollectorOptions{
+		InvoiceHistoryStart: testTime.Add(-30 * 24 * time.Hour),
+	})
+	expected := `
+# HELP vastai_invoice_amount_usd_total Amount of all invoices counted since the start of the invoice history, by invoice type
+# TYPE vastai_invoice_amount_usd_total counter
+vastai_invoice_amount_usd_total{account="test",type="charge"} 1.24
+vastai_invoice_amount_usd_total{account="test",type="fee"} 0.85
+vastai_invoice_amount_usd_total{account="test",type="payment"} 20
+vastai_invoice_amount_usd_total{account="test",type="payout"} 589.09
+# HELP vastai_invoices_total Number of invoices counted since the start of the invoice history, by invoice type
+# TYPE vastai_invoices_total counter
+vastai_invoices_total{account="test",type="charge"} 1
+vastai_invoices_total{account="test",type="fee"} 1
+vastai_invoices_total{account="test",type="payment"} 1
+vastai_invoices_total{account="test",type="payout"} 2
+# HELP vastai_invoice_history_synced_timestamp_seconds UNIX timestamp up to which the invoice history has been counted
+# TYPE vastai_invoice_history_synced_timestamp_seconds gauge
+vastai_invoice_history_synced_timestamp_seconds{account="test"} 1.69600326e+09
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"vastai_invoice_amount_usd_total", "vastai_invoices_total", "vastai_invoice_history_synced_timestamp_seconds"); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// newLegacyMetrics returns the descriptors of metrics under the names
+// they had before they were made consistent. They are only exported with
+// --metrics.legacy-names, with the same labels and values as before.
+func newLegacyMetrics() map[string]*prometheus.Desc {
+	machineLabels := []string{"account", "machine_id", "hostname"}
+	return map[string]*prometheus.Desc{
+		"account_balance":      prometheus.NewDesc("vastai_account_balance", "The current account balance of the user", []string{"account"}, nil),
+		"total_gpu_summary":    prometheus.NewDesc("vastai_summary_total_gpu", "Total GPU earnings in summary", []string{"account"}, nil),
+		"total_stor_summary":   prometheus.NewDesc("vastai_summary_total_stor", "Total storage earnings in summary", []string{"account"}, nil),
+		"total_bwu_summary":    prometheus.NewDesc("vastai_summary_total_bwu", "Total bandwidth upload earnings in summary", []string{"account"}, nil),
+		"total_bwd_summary":    prometheus.NewDesc("vastai_summary_total_bwd", "Total bandwidth download earnings in summary", []string{"account"}, nil),
+		"current_balance":      prometheus.NewDesc("vastai_current_balance", "Current balance", []string{"account"}, nil),
+		"current_service_fee":  prometheus.NewDesc("vastai_current_service_fee", "Current service fee", []string{"account"}, nil),
+		"current_total":        prometheus.NewDesc("vastai_current_total", "Current total", []string{"account"}, nil),
+		"current_credit":       prometheus.NewDesc("vastai_current_credit", "Current credit", []string{"account"}, nil),
+		"per_machine_gpu_earn": prometheus.NewDesc("vastai_per_machine_gpu_earn", "GPU earnings per machine", []string{"account", "machine_id"}, nil),
+		"per_machine_sto_earn": prometheus.NewDesc("vastai_per_machine_sto_earn", "Storage earnings per machine", []string{"account", "machine_id"}, nil),
+		"per_machine_bwu_earn": prometheus.NewDesc("vastai_per_machine_bwu_earn", "Bandwidth upload earnings per machine", []string{"account", "machine_id"}, nil),
+		"per_machine_bwd_earn": prometheus.NewDesc("vastai_per_machine_bwd_earn", "Bandwidth download earnings per machine", []string{"account", "machine_id"}, nil),
+		"per_day_gpu_earn":     prometheus.NewDesc("vastai_per_day_gpu_earn", "GPU earnings per day", []string{"account", "day"}, nil),
+		"per_day_sto_earn":     prometheus.NewDesc("vastai_per_day_sto_earn", "Storage earnings per day", []string{"account", "day"}, nil),
+		"per_day_bwu_earn":     prometheus.NewDesc("vastai_per_day_bwu_earn", "Bandwidth upload earnings per day", []string{"account", "day"}, nil),
+		"per_day_bwd_earn":     prometheus.NewDesc("vastai_per_day_bwd_earn", "Bandwidth download earnings per day", []string{"account", "day"}, nil),
+
+		"machine_id":               prometheus.NewDesc("vast_machine_id", "Machine ID", machineLabels, nil),
+		"machine_timeout":          prometheus.NewDesc("vast_machine_timeout", "Machine timeout", machineLabels, nil),
+		"machine_num_gpus":         prometheus.NewDesc("vast_machine_num_gpus", "Number of GPUs in the machine", machineLabels, nil),
+		"machine_gpu_name":         prometheus.NewDesc("vast_machine_gpu_name", "Type and total number of GPUs in the machine", []string{"account", "machine_id", "gpu_name", "hostname"}, nil),
+		"machine_total_flops":      prometheus.NewDesc("vast_machine_total_flops", "Machine total FLOPS", machineLabels, nil),
+		"machine_Listed":           prometheus.NewDesc("vast_machine_Listed", "Machine Listed", machineLabels, nil),
+		"machine_Verification":     prometheus.NewDesc("vast_machine_Verification", "Machine Verification", machineLabels, nil),
+		"machine_Reliability":      prometheus.NewDesc("vast_machine_Reliability", "Machine Reliability", machineLabels, nil),
+		"machine_InetUp":           prometheus.NewDesc("vastai_machine_InetUp", "Machine Inet Up", machineLabels, nil),
+		"machine_InetDown":         prometheus.NewDesc("vast_machine_InetDown", "Machine Inet Down", machineLabels, nil),
+		"machine_hostname":         prometheus.NewDesc("vast_machine_hostname", "Machine Hostname", machineLabels, nil),
+		"machine_max_disk_space":   prometheus.NewDesc("vastai_machine_max_disk_space", "Maximum disk space on machine", machineLabels, nil),
+		"machine_alloc_disk_space": prometheus.NewDesc("vastai_machine_alloc_disk_space", "Allocated disk space on machine", machineLabels, nil),
+		"machine_avail_disk_space": prometheus.NewDesc("vastai_machine_avail_disk_space", "Available disk space on machine", machineLabels, nil),
+		"gpu_rented_on_demand":     prometheus.NewDesc("vastai_machine_gpu_rented_on_demand", "Number of GPUs rented on-demand", machineLabels, nil),
+		"gpu_rented_on_reserved":   prometheus.NewDesc("vastai_machine_gpu_rented_on_reserved", "Number of GPUs rented on-reserved", machineLabels, nil),
+		"gpu_rented_bid_demand":    prometheus.NewDesc("vastai_machine_gpu_rented_bid_demand", "Number of GPUs rented bid-demand", machineLabels, nil),
+		"gpu_idle":                 prometheus.NewDesc("vastai_machine_gpu_idle", "Number of GPUs idle", machineLabels, nil),
+		"gpu_occupancy":            prometheus.NewDesc("vastai_machine_gpu_occupancy", "GPU occupancy state per machine and GPU number.", []string{"account", "machine_id", "Hostname", "gpu"}, nil),
+		"machine_earn_hour":        prometheus.NewDesc("vastai_machine_earn_hour", "Machine earn hour", machineLabels, nil),
+		"machine_ErrorDescription": prometheus.NewDesc("vastai_machine_ErrorDescription", "Machine Error Description", []string{"account", "machine_id", "hostname", "error_description"}, nil),
+		"machine_start_date":       prometheus.NewDesc("vastai_machine_start_date", "Start date of the machine as a UNIX timestamp", machineLabels, nil),
+		"machine_end_date":         prometheus.NewDesc("vastai_machine_end_date", "End date of the machine as a UNIX timestamp", machineLabels, nil),
+		"machine_listed_gpu_cost":  prometheus.NewDesc("vastai_machine_listed_gpu_cost", "Currently listed On-Demand Price", machineLabels, nil),
+		"machine_min_bid_price":    prometheus.NewDesc("vastai_machine_min_bid_price", "Currently listed Bid Price", machineLabels, nil),
+	}
+}
+
+// collectLegacy emits the legacy metrics of one account from s.
+func (c *VastCollector) collectLegacy(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	if s.earnings != nil {
+		c.collectLegacyEarnings(ch, account, s.earnings)
+	}
+	if s.machines != nil {
+		for _, machine := range s.machines.Machines {
+			c.collectLegacyMachine(ch, account, machine)
+		}
+	}
+	if s.account != nil {
+		ch <- prometheus.MustNewConstMetric(c.legacyMetrics["account_balance"], prometheus.GaugeValue, s.account.Balance, account)
+	}
+}
+
+func (c *VastCollector) collectLegacyEarnings(ch chan<- prometheus.Metric, account string, earningsData *vastai.MachineEarningsAPI) {
+	m := c.legacyMetrics
+	ch <- prometheus.MustNewConstMetric(m["total_gpu_summary"], prometheus.GaugeValue, earningsData.Summary.TotalGpu, account)
+	ch <- prometheus.MustNewConstMetric(m["total_stor_summary"], prometheus.GaugeValue, earningsData.Summary.TotalStor, account)
+	ch <- prometheus.MustNewConstMetric(m["total_bwu_summary"], prometheus.GaugeValue, earningsData.Summary.TotalBwu, account)
+	ch <- prometheus.MustNewConstMetric(m["total_bwd_summary"], prometheus.GaugeValue, earningsData.Summary.TotalBwd, account)
+	ch <- prometheus.MustNewConstMetric(m["current_balance"], prometheus.GaugeValue, earningsData.Current.Balance, account)
+	ch <- prometheus.MustNewConstMetric(m["current_service_fee"], prometheus.GaugeValue, earningsData.Current.ServiceFee, account)
+	ch <- prometheus.MustNewConstMetric(m["current_total"], prometheus.GaugeValue, earningsData.Current.Total, account)
+	ch <- prometheus.MustNewConstMetric(m["current_credit"], prometheus.GaugeValue, earningsData.Current.Credit, account)
+
+	for _, machine := range earningsData.PerMachine {
+		machineID := strconv.Itoa(machine.MachineID)
+		ch <- prometheus.MustNewConstMetric(m["per_machine_gpu_earn"], prometheus.GaugeValue, machine.GpuEarn, account, machineID)
+		ch <- prometheus.MustNewConstMetric(m["per_machine_sto_earn"], prometheus.GaugeValue, machine.StoEarn, account, machineID)
+		ch <- prometheus.MustNewConstMetric(m["per_machine_bwu_earn"], prometheus.GaugeValue, machine.BwuEarn, account, machineID)
+		ch <- prometheus.MustNewConstMetric(m["per_machine_bwd_earn"], prometheus.GaugeValue, machine.BwdEarn, account, machineID)
+	}
+	for _, day := range earningsData.PerDay {
+		dayNumber := strconv.Itoa(day.Day)
+		ch <- prometheus.MustNewConstMetric(m["per_day_gpu_earn"], prometheus.GaugeValue, day.GpuEarn, account, dayNumber)
+		ch <- prometheus.MustNewConstMetric(m["per_day_sto_earn"], prometheus.GaugeValue, day.StoEarn, account, dayNumber)
+		ch <- prometheus.MustNewConstMetric(m["per_day_bwu_earn"], prometheus.GaugeValue, day.BwuEarn, account, dayNumber)
+		ch <- prometheus.MustNewConstMetric(m["per_day_bwd_earn"], prometheus.GaugeValue, day.BwdEarn, account, dayNumber)
+	}
+}
+
+func (c *VastCollector) collectLegacyMachine(ch chan<- prometheus.Metric, account string, machine vastai.Machine) {
+	m := c.legacyMetrics
+	machineID := strconv.Itoa(machine.MachineID)
+	labels := []string{account, machineID, machine.Hostname}
+
+	ch <- prometheus.MustNewConstMetric(m["machine_listed_gpu_cost"], prometheus.GaugeValue, machine.ListedGpuCost, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_min_bid_price"], prometheus.GaugeValue, machine.MinBidPrice, labels...)
+	// These were exported in milliseconds despite their description.
+	ch <- prometheus.MustNewConstMetric(m["machine_start_date"], prometheus.GaugeValue, machine.StartDate*1000, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_end_date"], prometheus.GaugeValue, machine.EndDate*1000, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_id"], prometheus.GaugeValue, float64(machine.MachineID), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_timeout"], prometheus.GaugeValue, machine.Timeout, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_num_gpus"], prometheus.GaugeValue, float64(machine.NumGpus), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_gpu_name"], prometheus.GaugeValue, float64(machine.NumGpus), account, machineID, machine.GpuName, machine.Hostname)
+	ch <- prometheus.MustNewConstMetric(m["machine_total_flops"], prometheus.GaugeValue, machine.TotalFlops, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_Listed"], prometheus.GaugeValue, boolToFloat(machine.Listed), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_Verification"], prometheus.GaugeValue, boolToFloat(machine.Verification == "verified"), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_Reliability"], prometheus.GaugeValue, machine.Reliability2, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_InetUp"], prometheus.GaugeValue, machine.InetUp, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_InetDown"], prometheus.GaugeValue, machine.InetDown, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_hostname"], prometheus.GaugeValue, 1, labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_max_disk_space"], prometheus.GaugeValue, float64(machine.MaxDiskSpace), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_alloc_disk_space"], prometheus.GaugeValue, float64(machine.AllocDiskSpace), labels...)
+	ch <- prometheus.MustNewConstMetric(m["machine_avail_disk_space"], prometheus.GaugeValue, float64(machine.AvailDiskSpace), labels...)
+	ch <- prometheus.MustNewConstMetric(m["gpu_rented_on_demand"], prometheus.GaugeValue, float64(strings.Count(machine.GpuOccupancy, "D")), labels...)
+	ch <- prometheus.MustNewConstMetric(m["gpu_rented_on_reserved"], prometheus.GaugeValue, float64(strings.Count(machine.GpuOccupancy, "R")), labels...)
+	ch <- prometheus.MustNewConstMetric(m["gpu_rented_bid_demand"], prometheus.GaugeValue, float64(strings.Count(machine.GpuOccupancy, "I")), labels...)
+	ch <- prometheus.MustNewConstMetric(m["gpu_idle"], prometheus.GaugeValue, float64(strings.Count(machine.GpuOccupancy, "x")), labels...)
+	for i, char := range strings.ReplaceAll(machine.GpuOccupancy, " ", "") {
+		ch <- prometheus.MustNewConstMetric(m["gpu_occupancy"], prometheus.GaugeValue, legacyOccupancy(char), append(labels, strconv.Itoa(i))...)
+	}
+	ch <- prometheus.MustNewConstMetric(m["machine_earn_hour"], prometheus.GaugeValue, machine.EarnHour, labels...)
+	// 1 meant no error and 10 an error.
+	errorValue := 1.0
+	if machine.ErrorDescription != "" {
+		errorValue = 10.0
+	}
+	ch <- prometheus.MustNewConstMetric(m["machine_ErrorDescription"], prometheus.GaugeValue, errorValue, append(labels, machine.ErrorDescription)...)
+}
+
+// legacyOccupancy returns the value of the legacy occupancy metric of a
+// GPU from its character in the gpu_occupancy string. Unknown characters
+// were reported as idle.
+func legacyOccupancy(char rune) float64 {
+	switch char {
+	case 'R':
+		return 3
+	case 'D':
+		return 2
+	case 'I':
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// machineLiveness is what is known about whether a machine is online.
+type machineLiveness struct {
+	hostname string
+	// lastSeen is zero if the machine was never seen online.
+	lastSeen time.Time
+	// lastPresent is the last time the machine was in the machines
+	// response, online or not. The grace period counts from it.
+	lastPresent time.Time
+	// up is whether the machine was online in the latest machines
+	// response.
+	up bool
+}
+
+// machineLivenessTracker remembers machines that dropped out of the
+// machines response for a grace period, so that they are reported as down
+// instead of their series disappearing.
+type machineLivenessTracker struct {
+	machines map[int]machineLiveness
+}
+
+// update marks the machines in a successful machines response as up unless
+// Vast.ai reports a timeout for them, and the others as down. It forgets
+// machines that have been missing from the response for longer than
+// gracePeriod, and returns a copy of the result by machine ID.
+func (t *machineLivenessTracker) update(now time.Time, gracePeriod time.Duration, machines []vastai.Machine) map[int]machineLiveness {
+	if t.machines == nil {
+		t.machines = make(map[int]machineLiveness)
+	}
+	present := make(map[int]bool, len(machines))
+	for _, machine := range machines {
+		present[machine.MachineID] = true
+		liveness := machineLiveness{hostname: machine.Hostname, lastSeen: t.machines[machine.MachineID].lastSeen, lastPresent: now}
+		// Vast.ai keeps offline machines in the response, with the
+		// timeout set.
+		if machine.Timeout == 0 {
+			liveness.up = true
+			liveness.lastSeen = now
+		} else if update := latestClientUpdate(machine); update.After(liveness.lastSeen) {
+			liveness.lastSeen = update
+		}
+		t.machines[machine.MachineID] = liveness
+	}
+	for id, machine := range t.machines {
+		if present[id] {
+			continue
+		}
+		if now.Sub(machine.lastPresent) > gracePeriod {
+			delete(t.machines, id)
+			continue
+		}
+		machine.up = false
+		t.machines[id] = machine
+	}
+	return t.current()
+}
+
+// latestClientUpdate returns the time of the latest update of the rentals
+// of machine, zero if there is none.
+func latestClientUpdate(machine vastai.Machine) time.Time {
+	var latest float64
+	for _, client := range machine.Clients {
+		if client.LastUpdate > latest {
+			latest = client.LastUpdate
+		}
+	}
+	if latest == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(latest), 0)
+}
+
+// current returns a copy of the liveness of the known machines, or nil
+// before the first successful update.
+func (t *machineLivenessTracker) current() map[int]machineLiveness {
+	if t.machines == nil {
+		return nil
+	}
+	result := make(map[int]machineLiveness, len(t.machines))
+	for id, machine := range t.machines {
+		result[id] = machine
+	}
+	return result
+}
+
+// collectLiveness emits whether each known machine of s is up, and how
+// many machines are known and reporting.
+func (c *VastCollector) collectLiveness(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	reporting := 0
+	for id, machine := range s.liveness {
+		labels := []string{account, strconv.Itoa(id), machine.hostname}
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_up"], prometheus.GaugeValue, boolToFloat(machine.up), labels...)
+		if !machine.lastSeen.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.metrics["machine_last_seen"], prometheus.GaugeValue, float64(machine.lastSeen.Unix()), labels...)
+		}
+		if machine.up {
+			reporting++
+		}
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["machines_known"], prometheus.GaugeValue, float64(len(s.liveness)), account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machines_reporting"], prometheus.GaugeValue, float64(reporting), account)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+func TestMachineLiveness(t *testing.T) {
+	var tracker machineLivenessTracker
+	if got := tracker.current(); got != nil {
+		t.Errorf("current() before the first update = %v, want nil", got)
+	}
+
+	both := []vastai.Machine{{MachineID: 1, Hostname: "a"}, {MachineID: 2, Hostname: "b"}}
+	start := testTime.Add(-2 * time.Hour)
+	tracker.update(start, time.Hour, both)
+
+	// Machine 2 drops out of the response, within the grace period.
+	got := tracker.update(start.Add(30*time.Minute), time.Hour, both[:1])
+	if len(got) != 2 {
+		t.Fatalf("got %d machines within the grace period, want 2", len(got))
+	}
+	if m := got[1]; !m.up || !m.lastSeen.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("machine 1 = %+v, want up and seen at the latest update", m)
+	}
+	if m := got[2]; m.up || !m.lastSeen.Equal(start) || m.hostname != "b" {
+		t.Errorf("machine 2 = %+v, want down and last seen at the first update", m)
+	}
+
+	// A failed refresh keeps the previous state.
+	if got := tracker.current(); len(got) != 2 || got[2].up {
+		t.Errorf("current() = %v, want both machines with machine 2 down", got)
+	}
+
+	// After the grace period machine 2 is forgotten.
+	got = tracker.update(start.Add(2*time.Hour), time.Hour, both[:1])
+	if _, ok := got[2]; ok || len(got) != 1 {
+		t.Errorf("got %v after the grace period, want only machine 1", got)
+	}
+
+	// It is up again as soon as it reappears.
+	got = tracker.update(start.Add(3*time.Hour), time.Hour, both)
+	if m := got[2]; !m.up {
+		t.Errorf("machine 2 = %+v after reappearing, want up", m)
+	}
+}
+
+func TestMachineLivenessTimeout(t *testing.T) {
+	var tracker machineLivenessTracker
+	online := vastai.Machine{MachineID: 1, Hostname: "a"}
+	offline := vastai.Machine{MachineID: 1, Hostname: "a", Timeout: 3600,
+		Clients: []vastai.MachineClient{{LastUpdate: float64(testTime.Add(-90 * time.Minute).Unix())}}}
+	start := testTime.Add(-2 * time.Hour)
+
+	// Never seen online, only the update of its rental is known.
+	got := tracker.update(start, time.Hour, []vastai.Machine{offline})
+	if m := got[1]; m.up || !m.lastSeen.Equal(testTime.Add(-90*time.Minute)) {
+		t.Errorf("machine = %+v, want down and last seen at the update of its rental", m)
+	}
+
+	tracker.update(start.Add(time.Hour), time.Hour, []vastai.Machine{online})
+	// Still listed, but timed out. It stays known however long it is
+	// offline, since it is in the response.
+	got = tracker.update(testTime.Add(time.Hour), time.Hour, []vastai.Machine{offline})
+	if m, ok := got[1]; !ok || m.up || !m.lastSeen.Equal(start.Add(time.Hour)) {
+		t.Errorf("machine = %+v, want down and last seen online an hour after the start", m)
+	}
+}
+
+func TestMachineLivenessGracePeriodAfterTimeout(t *testing.T) {
+	var tracker machineLivenessTracker
+	offline := vastai.Machine{MachineID: 1, Hostname: "a", Timeout: 3600}
+	start := testTime.Add(-3 * time.Hour)
+
+	tracker.update(start, time.Hour, []vastai.Machine{{MachineID: 1, Hostname: "a"}})
+	// Timed out for two hours, then it drops out of the response.
+	tracker.update(start.Add(2*time.Hour), time.Hour, []vastai.Machine{offline})
+	got := tracker.update(start.Add(2*time.Hour+30*time.Minute), time.Hour, nil)
+	if m, ok := got[1]; !ok || m.up || !m.lastSeen.Equal(start) {
+		t.Errorf("machine = %+v, %v within the grace period, want down and last seen online at the start", m, ok)
+	}
+
+	got = tracker.update(start.Add(3*time.Hour+time.Minute), time.Hour, nil)
+	if _, ok := got[1]; ok {
+		t.Error("machine still known after the grace period")
+	}
+}
@@ -1,35 +1,155 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"prometheus-vastai/src/vastai"
 )
 
+// defaultAccountName is the account label used when a single API key is
+// given on the command line.
+const defaultAccountName = "default"
+
 func main() {
-	apiKey := flag.String("api-key", "", "Vast.ai API key")
+	if len(os.Args) > 1 && os.Args[1] == "backfill" {
+		if err := runBackfill(os.Args[2:]); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	apiKey := flag.String("api-key", "", "Vast.ai API key. Visible in the process list; prefer --api-key-file or $VASTAI_API_KEY.")
+	apiKeyFile := flag.String("api-key-file", "", "File containing the Vast.ai API key. Re-read on SIGHUP and when it changes.")
 	listenAddress := flag.String("listen-address", ":8622", "Address to listen on for HTTP requests.")
+	apiURL := flag.String("api-url", vastai.DefaultBaseURL, "Base URL of the Vast.ai API, e.g. to use a caching proxy.")
+	apiTimeout := flag.Duration("api-timeout", 30*time.Second, "Timeout of each request to the Vast.ai API.")
+	accountsFile := flag.String("accounts-file", "", "YAML file listing several named Vast.ai accounts to export, instead of a single --api-key.")
+	refreshInterval := flag.Duration("refresh-interval", time.Minute, "How often to fetch data from Vast.ai.")
+	legacyNames := flag.Bool("metrics.legacy-names", false, "Also export metrics under their old, inconsistent names, to migrate dashboards gradually.")
+	dailyEarnings := flag.Bool("metrics.daily-earnings", false, "Export the earnings of each day with a day label. Prefer the backfill command for earnings history.")
+	earningsWindowsFlag := flag.String("earnings-windows", defaultEarningsWindows, "Comma-separated windows to export earnings for: today, yesterday, month_to_date, previous_month, year_to_date or a number of days such as 7d. Each window including today is one more request per refresh, ended windows are fetched once a day.")
+	utilisationFile := flag.String("utilisation-file", "", "File to keep the GPU occupancy history in across restarts, for the rolling utilisation metrics.")
+	invoiceHistoryStart := flag.String("invoice-history-start", "", "Day to start counting invoices from, as YYYY-MM-DD, to export counters of charges, deposits, payouts and fees. Disabled if empty.")
+	invoiceStateFile := flag.String("invoice-state-file", "", "File to keep the invoice counters in across restarts, so that the history is not paged through again.")
+	machineGracePeriod := flag.Duration("machine-grace-period", time.Hour, "How long a machine missing from the Vast.ai response is reported as down before its metrics are dropped.")
+	notifyConfigFile := flag.String("notify-config", "", "YAML file configuring notifications about machine errors, de-listing, verification and reliability changes.")
+	offersRefreshInterval := flag.Duration("offers-refresh-interval", 5*time.Minute, "How often to fetch marketplace offers of the GPU models of your machines, 0 to disable.")
 	flag.Parse()
 
-	if *apiKey == "" {
-		fmt.Println("API key must be provided")
+	if *refreshInterval <= 0 {
+		fmt.Println("Refresh interval must be positive")
+		os.Exit(1)
+	}
+
+	earningsWindows, err := parseEarningsWindows(*earningsWindowsFlag)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	invoiceStart, err := parseInvoiceHistoryStart(*invoiceHistoryStart, *invoiceStateFile)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+
+	accounts, err := loadAccounts(*apiKey, *apiKeyFile, *accountsFile, *apiURL, *apiTimeout, true)
+	if err != nil {
+		fmt.Println(err)
 		os.Exit(1)
 	}
 
-	collector := NewVastCollector(*apiKey)
+	var notifier *Notifier
+	if *notifyConfigFile != "" {
+		config, err := loadNotifyConfig(*notifyConfigFile)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		notifier = NewNotifier(config)
+	}
+
+	collector := NewVastCollector(accounts, CollectorOptions{
+		LegacyNames:         *legacyNames,
+		UtilisationFile:     *utilisationFile,
+		MachineGracePeriod:  *machineGracePeriod,
+		Notifier:            notifier,
+		EarningsWindows:     earningsWindows,
+		DailyEarnings:       *dailyEarnings,
+		InvoiceHistoryStart: invoiceStart,
+		InvoiceStateFile:    *invoiceStateFile,
+	})
 	prometheus.DefaultRegisterer.Unregister(prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}))
 	prometheus.DefaultRegisterer.Unregister(prometheus.NewGoCollector())
 	prometheus.MustRegister(collector)
+	if notifier != nil {
+		prometheus.MustRegister(notifier)
+		go notifier.Run()
+	}
+	go collector.Run(*refreshInterval)
+
+	if *offersRefreshInterval > 0 {
+		// Offers are public, any account's client will do.
+		offers := NewOffersCollector(accounts[0].Client, collector)
+		prometheus.MustRegister(offers)
+		go offers.Run(*offersRefreshInterval)
+	}
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("<h1>Vast.ai Exporter</h1><p><a href='/metrics'>Metrics</a></p>"))
+		w.Write([]byte("<h1>Vast.ai Exporter</h1><p><a href='/metrics'>Metrics</a></p><p><a href='/events'>Rental events</a></p>"))
 	})
 	http.Handle("/metrics", promhttp.Handler())
+	http.Handle("/events", collector.Events())
 	log.Printf("Starting vast.ai exporter on %s", *listenAddress)
 	log.Fatal(http.ListenAndServe(*listenAddress, nil))
 }
+
+// loadAccounts creates a client for each account given by the API key
+// flags or the accounts file, and if watch is set keeps their API keys in
+// sync with their files.
+func loadAccounts(apiKey, apiKeyFile, accountsFile, apiURL string, timeout time.Duration, watch bool) ([]Account, error) {
+	accountConfigs := []accountConfig{{Name: defaultAccountName, APIKey: apiKey, APIKeyFile: apiKeyFile}}
+	if accountsFile != "" {
+		if apiKey != "" || apiKeyFile != "" {
+			return nil, errors.New("--accounts-file cannot be combined with --api-key or --api-key-file")
+		}
+		var err error
+		accountConfigs, err = loadAccountsConfig(accountsFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var accounts []Account
+	for _, config := range accountConfigs {
+		key, err := resolveAPIKey(config.APIKey, config.APIKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("account %s: %w", config.Name, err)
+		}
+		client, err := vastai.NewClient(vastai.Config{
+			BaseURL:   apiURL,
+			APIKey:    key,
+			Timeout:   timeout,
+			UserAgent: "vastai_exporter",
+		})
+		if err != nil {
+			return nil, err
+		}
+		if watch && config.APIKeyFile != "" {
+			watcher := &apiKeyWatcher{path: config.APIKeyFile, client: client, current: key}
+			go watcher.watch()
+		}
+		accounts = append(accounts, Account{Name: config.Name, Client: client})
+	}
+	return accounts, nil
+}
@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// Kinds of notifications.
+const (
+	alertErrorRaised        = "machine_error"
+	alertErrorCleared       = "machine_error_cleared"
+	alertDelisted           = "machine_delisted"
+	alertListed             = "machine_listed"
+	alertVerificationChange = "verification_changed"
+	alertReliabilityDrop    = "reliability_dropped"
+)
+
+// alert is a change of a machine worth notifying about.
+type alert struct {
+	Time      time.Time `json:"time"`
+	Account   string    `json:"account"`
+	MachineID int       `json:"machine_id"`
+	Hostname  string    `json:"hostname"`
+	Kind      string    `json:"kind"`
+	// Previous and new value of the field that changed.
+	From    string `json:"from,omitempty"`
+	To      string `json:"to,omitempty"`
+	Message string `json:"message"`
+}
+
+// notificationBackend delivers alerts somewhere.
+type notificationBackend interface {
+	name() string
+	send(ctx context.Context, a alert) error
+}
+
+// notifyQueueSize is how many alerts may wait to be sent before further
+// alerts are dropped.
+const notifyQueueSize = 100
+
+// machineHealth is the part of a machine that alerts are detected from.
+type machineHealth struct {
+	errorDescription string
+	listed           bool
+	verification     string
+	// reliability is the reliability that drops are measured from: the
+	// last one notified about, or the highest since.
+	reliability float64
+}
+
+// Notifier watches the machines of all accounts across refreshes and
+// queues deduplicated, rate limited alerts, which Run sends through its
+// backends.
+type Notifier struct {
+	config   notifyConfig
+	backends []notificationBackend
+	metrics  map[string]*prometheus.Desc
+	queue    chan alert
+
+	mu       sync.Mutex
+	previous map[string]map[int]machineHealth
+	// lastSent is when each alert was last sent, by dedupKey.
+	lastSent map[string]time.Time
+	// sent holds the times of the alerts sent in the last hour.
+	sent       []time.Time
+	suppressed map[string]float64
+	results    map[backendResult]float64
+}
+
+type backendResult struct {
+	backend string
+	result  string
+}
+
+func NewNotifier(config notifyConfig) *Notifier {
+	n := &Notifier{
+		config:     config,
+		previous:   make(map[string]map[int]machineHealth),
+		lastSent:   make(map[string]time.Time),
+		queue:      make(chan alert, notifyQueueSize),
+		suppressed: map[string]float64{"duplicate": 0, "rate_limited": 0, "queue_full": 0},
+		results:    make(map[backendResult]float64),
+		metrics: map[string]*prometheus.Desc{
+			"notifications": prometheus.NewDesc(
+				"vastai_notifications_total",
+				"Number of alerts passed to each notification backend, by whether sending succeeded",
+				[]string{"backend", "result"}, nil,
+			),
+			"notifications_suppressed": prometheus.NewDesc(
+				"vastai_notifications_suppressed_total",
+				"Number of alerts not sent because they were duplicates, over the rate limit or the queue was full",
+				[]string{"reason"}, nil,
+			),
+		},
+	}
+	client := &http.Client{Timeout: 10 * time.Second}
+	for _, backend := range config.Backends {
+		switch backend.Type {
+		case "webhook":
+			n.backends = append(n.backends, &webhookBackend{url: backend.URL, client: client})
+		case "slack":
+			n.backends = append(n.backends, &slackBackend{url: backend.URL, client: client})
+		case "smtp":
+			n.backends = append(n.backends, &smtpBackend{
+				address:  backend.Address,
+				username: backend.Username,
+				password: backend.Password,
+				from:     backend.From,
+				to:       backend.To,
+			})
+		}
+	}
+	for _, backend := range n.backends {
+		for _, result := range []string{"success", "failure"} {
+			n.results[backendResult{backend.name(), result}] = 0
+		}
+	}
+	return n
+}
+
+// observe compares machines with the previous refresh of account and queues
+// alerts for what changed. Machines seen for the first time only become
+// the baseline, and machines missing from a refresh keep their state. It
+// never waits for alerts to be sent.
+func (n *Notifier) observe(account string, at time.Time, machines []vastai.Machine) {
+	n.mu.Lock()
+	previous := n.previous[account]
+	current := make(map[int]machineHealth, len(machines))
+	var alerts []alert
+	for _, machine := range machines {
+		health := machineHealth{
+			errorDescription: machine.ErrorDescription,
+			listed:           machine.Listed,
+			verification:     machine.Verification,
+			reliability:      machine.Reliability2,
+		}
+		before, ok := previous[machine.MachineID]
+		if !ok {
+			current[machine.MachineID] = health
+			continue
+		}
+		for _, a := range n.diff(before, health) {
+			a.Time = at
+			a.Account = account
+			a.MachineID = machine.MachineID
+			a.Hostname = machine.Hostname
+			a.Message = fmt.Sprintf("Machine %d (%s) of account %s: %s", a.MachineID, a.Hostname, account, a.Message)
+			alerts = append(alerts, a)
+		}
+		if health.reliability < before.reliability && before.reliability-health.reliability < n.config.ReliabilityDrop {
+			// Keep measuring from the reference, so that gradual drops
+			// are noticed too.
+			health.reliability = before.reliability
+		}
+		current[machine.MachineID] = health
+	}
+	for machineID, health := range previous {
+		if _, ok := current[machineID]; !ok {
+			current[machineID] = health
+		}
+	}
+	n.previous[account] = current
+	n.enqueue(alerts)
+	n.mu.Unlock()
+}
+
+// Run sends the queued alerts. It never returns.
+func (n *Notifier) Run() {
+	for a := range n.queue {
+		n.send(a)
+	}
+}
+
+// diff returns the alerts between two refreshes of a machine, without the
+// fields common to all alerts of the machine. Reliability drops are
+// measured from the reference reliability of before.
+func (n *Notifier) diff(before, after machineHealth) []alert {
+	var alerts []alert
+	switch {
+	case after.errorDescription != "" && after.errorDescription != before.errorDescription:
+		alerts = append(alerts, alert{Kind: alertErrorRaised, From: before.errorDescription, To: after.errorDescription,
+			Message: "error: " + after.errorDescription})
+	case after.errorDescription == "" && before.errorDescription != "":
+		alerts = append(alerts, alert{Kind: alertErrorCleared, From: before.errorDescription,
+			Message: "error cleared"})
+	}
+	if before.listed && !after.listed {
+		alerts = append(alerts, alert{Kind: alertDelisted, Message: "no longer listed"})
+	}
+	if !before.listed && after.listed {
+		alerts = append(alerts, alert{Kind: alertListed, Message: "listed again"})
+	}
+	if before.verification != after.verification {
+		alerts = append(alerts, alert{Kind: alertVerificationChange, From: before.verification, To: after.verification,
+			Message: fmt.Sprintf("verification changed from %q to %q", before.verification, after.verification)})
+	}
+	if before.reliability-after.reliability >= n.config.ReliabilityDrop {
+		from, to := fmt.Sprintf("%.4f", before.reliability), fmt.Sprintf("%.4f", after.reliability)
+		alerts = append(alerts, alert{Kind: alertReliabilityDrop, From: from, To: to,
+			Message: fmt.Sprintf("reliability dropped from %s to %s", from, to)})
+	}
+	return alerts
+}
+
+// enqueue queues alerts for sending, without waiting, except those that
+// were already sent within the dedup window, that exceed the rate limit or
+// that find the queue full. Only queued alerts are recorded as sent. It
+// must be called with n.mu held.
+func (n *Notifier) enqueue(alerts []alert) {
+	for _, a := range alerts {
+		key := dedupKey(a)
+		if last, ok := n.lastSent[key]; ok && a.Time.Sub(last) < n.config.DedupWindow {
+			n.suppressed["duplicate"]++
+			continue
+		}
+		for len(n.sent) > 0 && a.Time.Sub(n.sent[0]) >= time.Hour {
+			n.sent = n.sent[1:]
+		}
+		if len(n.sent) >= n.config.MaxPerHour {
+			log.Printf("Not sending notification, more than %d in the last hour: %s", n.config.MaxPerHour, a.Message)
+			n.suppressed["rate_limited"]++
+			continue
+		}
+		select {
+		case n.queue <- a:
+			n.lastSent[key] = a.Time
+			n.sent = append(n.sent, a.Time)
+		default:
+			log.Printf("Not sending notification, %d are waiting to be sent: %s", notifyQueueSize, a.Message)
+			n.suppressed["queue_full"]++
+		}
+	}
+}
+
+// dedupKey identifies alerts that are duplicates of each other.
+func dedupKey(a alert) string {
+	return fmt.Sprintf("%s/%d/%s/%s", a.Account, a.MachineID, a.Kind, a.To)
+}
+
+// send passes an alert to every backend.
+func (n *Notifier) send(a alert) {
+	log.Printf("Notifying: %s", a.Message)
+	for _, backend := range n.backends {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		err := backend.send(ctx, a)
+		cancel()
+		result := "success"
+		if err != nil {
+			log.Printf("Failed to send notification through %s: %s", backend.name(), err)
+			result = "failure"
+		}
+		n.mu.Lock()
+		n.results[backendResult{backend.name(), result}]++
+		n.mu.Unlock()
+	}
+}
+
+func (n *Notifier) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range n.metrics {
+		ch <- desc
+	}
+}
+
+func (n *Notifier) Collect(ch chan<- prometheus.Metric) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for key, value := range n.results {
+		ch <- prometheus.MustNewConstMetric(n.metrics["notifications"], prometheus.CounterValue, value, key.backend, key.result)
+	}
+	for reason, value := range n.suppressed {
+		ch <- prometheus.MustNewConstMetric(n.metrics["notifications_suppressed"], prometheus.CounterValue, value, reason)
+	}
+}
+
+// webhookBackend posts each alert as JSON.
+type webhookBackend struct {
+	url    string
+	client *http.Client
+}
+
+func (b *webhookBackend) name() string { return "webhook" }
+
+func (b *webhookBackend) send(ctx context.Context, a alert) error {
+	return postJSON(ctx, b.client, b.url, a)
+}
+
+// slackBackend posts each alert to a Slack-compatible incoming webhook.
+type slackBackend struct {
+	url    string
+	client *http.Client
+}
+
+func (b *slackBackend) name() string { return "slack" }
+
+func (b *slackBackend) send(ctx context.Context, a alert) error {
+	return postJSON(ctx, b.client, b.url, struct {
+		Text string `json:"text"`
+	}{":warning: " + a.Message})
+}
+
+func postJSON(ctx context.Context, client *http.Client, url string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// smtpBackend mails each alert, using STARTTLS if the server supports it.
+// It authenticates only if a username is set, which net/smtp allows over
+// unencrypted connections to localhost only.
+type smtpBackend struct {
+	address  string
+	username string
+	password string
+	from     string
+	to       []string
+}
+
+func (b *smtpBackend) name() string { return "smtp" }
+
+func (b *smtpBackend) send(ctx context.Context, a alert) error {
+	host, _, err := net.SplitHostPort(b.address)
+	if err != nil {
+		return err
+	}
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", b.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: host}); err != nil {
+			return err
+		}
+	}
+	if b.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", b.username, b.password, host)); err != nil {
+			return err
+		}
+	}
+	if err := client.Mail(b.from); err != nil {
+		return err
+	}
+	for _, to := range b.to {
+		if err := client.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	// The message holds hostnames and errors from the API, which must not
+	// be able to end the subject header. Q-encoding takes care of line
+	// breaks and non-ASCII characters.
+	subject := mime.QEncoding.Encode("utf-8", "[vastai] "+a.Message)
+	fmt.Fprintf(w, "From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		b.from, strings.Join(b.to, ", "), subject, a.Time.Format(time.RFC1123Z), a.Message)
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return client.Quit()
+}
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// recordingServer is a stand-in for webhook receivers that keeps the
+// bodies of the requests it gets.
+type recordingServer struct {
+	*httptest.Server
+	mu     sync.Mutex
+	bodies []map[string]interface{}
+}
+
+func newRecordingServer(t *testing.T, status int) *recordingServer {
+	s := &recordingServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("decoding notification: %s", err)
+		}
+		s.mu.Lock()
+		s.bodies = append(s.bodies, body)
+		s.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *recordingServer) received() []map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]map[string]interface{}(nil), s.bodies...)
+}
+
+// newSMTPServer starts a stand-in SMTP server that accepts any mail and
+// sends the data of each message to the returned channel.
+func newSMTPServer(t *testing.T) (string, <-chan string) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan string, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSMTP(conn, messages)
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func serveSMTP(conn net.Conn, messages chan<- string) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost ESMTP")
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		switch command := strings.ToUpper(strings.Fields(line + " x")[0]); command {
+		case "EHLO", "HELO":
+			reply("250 localhost")
+		case "DATA":
+			reply("354 go ahead")
+			var data strings.Builder
+			for {
+				line, err := r.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(line)
+			}
+			messages <- data.String()
+			reply("250 ok")
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("250 ok")
+		}
+	}
+}
+
+// deliver sends the alerts queued by n, like Run, and returns once the
+// queue is empty.
+func deliver(n *Notifier) {
+	for {
+		select {
+		case a := <-n.queue:
+			n.send(a)
+		default:
+			return
+		}
+	}
+}
+
+func testMachine(id int, mutate func(*vastai.Machine)) vastai.Machine {
+	machine := vastai.Machine{MachineID: id, Hostname: "rig", Listed: true, Verification: "verified", Reliability2: 0.99}
+	if mutate != nil {
+		mutate(&machine)
+	}
+	return machine
+}
+
+func TestNotifierBackends(t *testing.T) {
+	webhook := newRecordingServer(t, http.StatusOK)
+	slack := newRecordingServer(t, http.StatusOK)
+	smtpAddress, mails := newSMTPServer(t)
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		MaxPerHour:      10,
+		Backends: []notifyBackendConfig{
+			{Type: "webhook", URL: webhook.URL},
+			{Type: "slack", URL: slack.URL},
+			{Type: "smtp", Address: smtpAddress, From: "exporter@example.com", To: []string{"ops@example.com"}},
+		},
+	})
+
+	notifier.observe("a", testTime.Add(-time.Minute), []vastai.Machine{testMachine(1, nil)})
+	deliver(notifier)
+	if got := webhook.received(); len(got) != 0 {
+		t.Fatalf("got %d notifications for the baseline, want none", len(got))
+	}
+	notifier.observe("a", testTime, []vastai.Machine{testMachine(1, func(m *vastai.Machine) {
+		m.ErrorDescription = "GPU fell off the bus"
+	})})
+	deliver(notifier)
+
+	got := webhook.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d webhook notifications, want 1", len(got))
+	}
+	if got[0]["kind"] != alertErrorRaised || got[0]["to"] != "GPU fell off the bus" || got[0]["machine_id"] != 1.0 {
+		t.Errorf("webhook notification = %v", got[0])
+	}
+	if got := slack.received(); len(got) != 1 || !strings.Contains(got[0]["text"].(string), "GPU fell off the bus") {
+		t.Errorf("slack notifications = %v, want one with the error", got)
+	}
+	select {
+	case mail := <-mails:
+		if !strings.Contains(mail, "Subject: [vastai] Machine 1 (rig) of account a: error: GPU fell off the bus") {
+			t.Errorf("mail = %q, want the error in the subject", mail)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no mail received")
+	}
+}
+
+func TestNotifierAlerts(t *testing.T) {
+	webhook := newRecordingServer(t, http.StatusOK)
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		MaxPerHour:      10,
+		Backends:        []notifyBackendConfig{{Type: "webhook", URL: webhook.URL}},
+	})
+
+	notifier.observe("a", testTime.Add(-time.Minute), []vastai.Machine{testMachine(1, nil), testMachine(2, nil)})
+	notifier.observe("a", testTime, []vastai.Machine{
+		testMachine(1, func(m *vastai.Machine) {
+			m.Listed = false
+			m.Verification = "deverified"
+			m.Reliability2 = 0.97
+		}),
+		// A small drop in reliability is not worth a notification.
+		testMachine(2, func(m *vastai.Machine) { m.Reliability2 = 0.985 }),
+	})
+	deliver(notifier)
+
+	kinds := make(map[string]bool)
+	for _, body := range webhook.received() {
+		kinds[body["kind"].(string)] = true
+	}
+	for _, kind := range []string{alertDelisted, alertVerificationChange, alertReliabilityDrop} {
+		if !kinds[kind] {
+			t.Errorf("no %s notification", kind)
+		}
+	}
+	if len(kinds) != 3 {
+		t.Errorf("got notifications %v, want 3", kinds)
+	}
+}
+
+func TestNotifierDedupAndRateLimit(t *testing.T) {
+	webhook := newRecordingServer(t, http.StatusInternalServerError)
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		MaxPerHour:      2,
+		Backends:        []notifyBackendConfig{{Type: "webhook", URL: webhook.URL}},
+	})
+	listed := func(listed bool) []vastai.Machine {
+		return []vastai.Machine{testMachine(1, func(m *vastai.Machine) { m.Listed = listed })}
+	}
+
+	notifier.observe("a", testTime.Add(-5*time.Minute), listed(true))
+	notifier.observe("a", testTime.Add(-4*time.Minute), listed(false))
+	notifier.observe("a", testTime.Add(-3*time.Minute), listed(true))
+	// Flapping again within the dedup window is not sent again.
+	notifier.observe("a", testTime.Add(-2*time.Minute), listed(false))
+	// Another machine going down exceeds the rate limit.
+	notifier.observe("b", testTime.Add(-2*time.Minute), listed(true))
+	notifier.observe("b", testTime.Add(-time.Minute), listed(false))
+	deliver(notifier)
+
+	if got := webhook.received(); len(got) != 2 {
+		t.Errorf("sent %d notifications, want 2", len(got))
+	}
+	if got := notifier.suppressed["duplicate"]; got != 1 {
+		t.Errorf("suppressed %v duplicates, want 1", got)
+	}
+	if got := notifier.suppressed["rate_limited"]; got != 1 {
+		t.Errorf("suppressed %v over the rate limit, want 1", got)
+	}
+	if got := notifier.results[backendResult{"webhook", "failure"}]; got != 2 {
+		t.Errorf("counted %v failures of the webhook returning 500, want 2", got)
+	}
+
+	// An hour later both are allowed again.
+	notifier.observe("a", testTime.Add(time.Hour), listed(true))
+	deliver(notifier)
+	if got := webhook.received(); len(got) != 3 {
+		t.Errorf("sent %d notifications after an hour, want 3", len(got))
+	}
+}
+
+func TestNotifierGradualReliabilityDrop(t *testing.T) {
+	webhook := newRecordingServer(t, http.StatusOK)
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		MaxPerHour:      10,
+		Backends:        []notifyBackendConfig{{Type: "webhook", URL: webhook.URL}},
+	})
+	reliability := func(r float64) []vastai.Machine {
+		return []vastai.Machine{testMachine(1, func(m *vastai.Machine) { m.Reliability2 = r })}
+	}
+
+	// Rising raises the reference, each step down is too small to notify
+	// about, but together they are not.
+	for i, r := range []float64{0.98, 0.99, 0.986, 0.982, 0.978, 0.975} {
+		notifier.observe("a", testTime.Add(time.Duration(i)*time.Minute), reliability(r))
+	}
+	deliver(notifier)
+	got := webhook.received()
+	if len(got) != 1 {
+		t.Fatalf("got %d notifications, want 1", len(got))
+	}
+	if got[0]["kind"] != alertReliabilityDrop || got[0]["from"] != "0.9900" || got[0]["to"] != "0.9780" {
+		t.Errorf("notification = %v, want a drop from 0.9900 to 0.9780", got[0])
+	}
+
+	// Further drops are measured from the reliability notified about.
+	notifier.observe("a", testTime.Add(time.Hour), reliability(0.972))
+	deliver(notifier)
+	if got := webhook.received(); len(got) != 1 {
+		t.Errorf("got %d notifications after a small drop, want still 1", len(got))
+	}
+}
+
+func TestNotifierDoesNotWaitForBackends(t *testing.T) {
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		MaxPerHour:      1000,
+		// Nothing listens on this address, but nothing is sent either.
+		Backends: []notifyBackendConfig{{Type: "webhook", URL: "http://127.0.0.1:1/"}},
+	})
+	var machines []vastai.Machine
+	for id := 1; id <= notifyQueueSize+1; id++ {
+		machines = append(machines, testMachine(id, nil))
+	}
+	notifier.observe("a", testTime.Add(-time.Minute), machines)
+	for i := range machines {
+		machines[i].Listed = false
+	}
+	notifier.observe("a", testTime, machines)
+
+	if got := len(notifier.queue); got != notifyQueueSize {
+		t.Errorf("queued %d notifications, want %d", got, notifyQueueSize)
+	}
+	if got := notifier.suppressed["queue_full"]; got != 1 {
+		t.Errorf("suppressed %v notifications with a full queue, want 1", got)
+	}
+}
+
+func TestNotifierQueueFullIsNotRecordedAsSent(t *testing.T) {
+	webhook := newRecordingServer(t, http.StatusOK)
+	notifier := NewNotifier(notifyConfig{
+		ReliabilityDrop: 0.01,
+		DedupWindow:     time.Hour,
+		// Room for the queued alerts and the dropped one listed again and
+		// de-listed again.
+		MaxPerHour: notifyQueueSize + 2,
+		Backends:   []notifyBackendConfig{{Type: "webhook", URL: webhook.URL}},
+	})
+	var machines []vastai.Machine
+	for id := 1; id <= notifyQueueSize+1; id++ {
+		machines = append(machines, testMachine(id, nil))
+	}
+	notifier.observe("a", testTime.Add(-2*time.Minute), machines)
+	for i := range machines {
+		machines[i].Listed = false
+	}
+	notifier.observe("a", testTime.Add(-time.Minute), machines)
+	if got := notifier.suppressed["queue_full"]; got != 1 {
+		t.Fatalf("suppressed %v notifications with a full queue, want 1", got)
+	}
+	if got := len(notifier.sent); got != notifyQueueSize {
+		t.Errorf("recorded %d notifications as sent, want the %d queued", got, notifyQueueSize)
+	}
+	deliver(notifier)
+
+	// The dropped alert is neither a duplicate nor over the rate limit
+	// when the machine is listed and de-listed again.
+	last := machines[notifyQueueSize:]
+	notifier.observe("a", testTime, []vastai.Machine{testMachine(last[0].MachineID, nil)})
+	notifier.observe("a", testTime.Add(time.Minute), last)
+	deliver(notifier)
+	if got := len(webhook.received()); got != notifyQueueSize+2 {
+		t.Errorf("sent %d notifications, want %d", got, notifyQueueSize+2)
+	}
+	if got := notifier.suppressed["duplicate"] + notifier.suppressed["rate_limited"]; got != 0 {
+		t.Errorf("suppressed %v notifications as duplicate or rate limited, want 0", got)
+	}
+}
+
+func TestSMTPSubjectIsEncoded(t *testing.T) {
+	smtpAddress, mails := newSMTPServer(t)
+	backend := &smtpBackend{address: smtpAddress, from: "exporter@example.com", to: []string{"ops@example.com"}}
+	err := backend.send(context.Background(), alert{
+		Time:    testTime,
+		Message: "error: disk full\r\nBcc: victim@example.com\r\n\r\nforged body",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case mail := <-mails:
+		headers := strings.SplitN(mail, "\r\n\r\n", 2)[0]
+		for _, line := range strings.Split(headers, "\r\n") {
+			if strings.HasPrefix(line, "Bcc:") || strings.HasPrefix(line, "forged") {
+				t.Errorf("line breaks in the message injected headers: %q", headers)
+			}
+		}
+		if !strings.Contains(headers, "Subject: =?utf-8?q?") {
+			t.Errorf("headers = %q, want a Q-encoded subject", headers)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("no mail received")
+	}
+}
@@ -0,0 +1,73 @@
+package main
+
+import "unicode"
+
+// gpuState is the occupancy of a single GPU.
+type gpuState int
+
+const (
+	gpuIdle gpuState = iota
+	gpuInterruptible
+	gpuOnDemand
+	gpuReserved
+	gpuUnknown
+)
+
+// gpuStates lists every gpuState, in the order they are exported.
+var gpuStates = []gpuState{gpuReserved, gpuOnDemand, gpuInterruptible, gpuIdle, gpuUnknown}
+
+func (s gpuState) String() string {
+	switch s {
+	case gpuIdle:
+		return "idle"
+	case gpuInterruptible:
+		return "interruptible"
+	case gpuOnDemand:
+		return "on_demand"
+	case gpuReserved:
+		return "reserved"
+	}
+	return "unknown"
+}
+
+// parseOccupancy parses the gpu_occupancy string of a machine, which has
+// one character per GPU, usually but not always separated by spaces:
+// R for reserved, D for on-demand, I for interruptible and x for idle.
+// Other characters, and GPUs missing from a string shorter than numGpus,
+// are unknown.
+func parseOccupancy(occupancy string, numGpus int) []gpuState {
+	states := make([]gpuState, 0, numGpus)
+	for _, char := range occupancy {
+		if unicode.IsSpace(char) {
+			continue
+		}
+		state := gpuUnknown
+		switch char {
+		case 'R':
+			state = gpuReserved
+		case 'D':
+			state = gpuOnDemand
+		case 'I':
+			state = gpuInterruptible
+		case 'x':
+			state = gpuIdle
+		}
+		states = append(states, state)
+	}
+	for len(states) < numGpus {
+		states = append(states, gpuUnknown)
+	}
+	return states
+}
+
+// countStates returns the number of GPUs in each state.
+func countStates(states []gpuState) map[gpuState]int {
+	counts := make(map[gpuState]int, len(gpuStates))
+	for _, state := range gpuStates {
+		counts[state] = 0
+	}
+	for _, state := range states {
+		counts[state]++
+	}
+	return counts
+}
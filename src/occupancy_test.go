@@ -0,0 +1,62 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseOccupancy(t *testing.T) {
+	const (
+		R = gpuReserved
+		D = gpuOnDemand
+		I = gpuInterruptible
+		x = gpuIdle
+		u = gpuUnknown
+	)
+	for _, test := range []struct {
+		name      string
+		occupancy string
+		numGpus   int
+		want      []gpuState
+	}{
+		{"double spaced with trailing space", "D  D  I  x ", 4, []gpuState{D, D, I, x}},
+		{"single spaced", "R D I x", 4, []gpuState{R, D, I, x}},
+		{"without spaces", "RDIx", 4, []gpuState{R, D, I, x}},
+		{"leading and mixed whitespace", " x\tD\nx", 3, []gpuState{x, D, x}},
+		{"all idle", "x x x x x x x x", 8, []gpuState{x, x, x, x, x, x, x, x}},
+		{"single GPU", "D", 1, []gpuState{D}},
+		{"empty", "", 0, []gpuState{}},
+		{"empty with GPUs", "", 2, []gpuState{u, u}},
+		{"shorter than the number of GPUs", "R R x", 4, []gpuState{R, R, x, u}},
+		{"longer than the number of GPUs", "x x x", 2, []gpuState{x, x, x}},
+		{"unknown characters", "D ? X d -", 5, []gpuState{D, u, u, u, u}},
+		{"non-ASCII", "x é", 2, []gpuState{x, u}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got := parseOccupancy(test.occupancy, test.numGpus)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("parseOccupancy(%q, %d) = %v, want %v", test.occupancy, test.numGpus, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCountStates(t *testing.T) {
+	got := countStates(parseOccupancy("D D I x ? R", 7))
+	want := map[gpuState]int{
+		gpuReserved:      1,
+		gpuOnDemand:      2,
+		gpuInterruptible: 1,
+		gpuIdle:          1,
+		gpuUnknown:       2,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("countStates() = %v, want %v", got, want)
+	}
+
+	for state, count := range countStates(nil) {
+		if count != 0 {
+			t.Errorf("countStates(nil)[%s] = %d, want 0", state, count)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/montanaflynn/stats"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// OffersCollector exports statistics of the public marketplace offers of
+// every GPU model found among the machines of a VastCollector, so that
+// prices can be compared against the market.
+type OffersCollector struct {
+	client   *vastai.Client
+	machines *VastCollector
+	metrics  map[string]*prometheus.Desc
+
+	mu       sync.RWMutex
+	snapshot *offersSnapshot
+}
+
+// offersSnapshot holds the offers fetched during one refresh, by GPU model.
+// Models whose offers could not be fetched map to nil.
+type offersSnapshot struct {
+	offers map[string][]vastai.Offer
+}
+
+// offerGroup is the key that offer statistics are aggregated by.
+type offerGroup struct {
+	gpuName      string
+	verification string
+}
+
+func NewOffersCollector(client *vastai.Client, machines *VastCollector) *OffersCollector {
+	return &OffersCollector{
+		client:   client,
+		machines: machines,
+		metrics: map[string]*prometheus.Desc{
+			"offers": prometheus.NewDesc(
+				"vastai_offers",
+				"Number of on-demand marketplace offers",
+				[]string{"gpu_name", "verification"}, nil,
+			),
+			"offer_gpus": prometheus.NewDesc(
+				"vastai_offer_gpus",
+				"Number of GPUs in on-demand marketplace offers, by whether they are rented",
+				[]string{"gpu_name", "verification", "rented"}, nil,
+			),
+			"offer_gpu_price": prometheus.NewDesc(
+				"vastai_offer_gpu_price_usd",
+				"On-demand price per GPU-hour of marketplace offers, quantile 0 is the minimum and 1 the maximum",
+				[]string{"gpu_name", "verification"}, nil,
+			),
+			"offer_dlperf": prometheus.NewDesc(
+				"vastai_offer_dlperf_per_gpu",
+				"DLPerf score per GPU of marketplace offers, quantile 0 is the minimum and 1 the maximum",
+				[]string{"gpu_name", "verification"}, nil,
+			),
+			"offers_scrape_success": prometheus.NewDesc(
+				"vastai_offers_scrape_success",
+				"Whether the last fetch of the marketplace offers of a GPU model succeeded",
+				[]string{"gpu_name"}, nil,
+			),
+		},
+	}
+}
+
+// Run refreshes the offers once the machines are known and then once per
+// interval. It never returns.
+func (c *OffersCollector) Run(interval time.Duration) {
+	<-c.machines.ready
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.refresh()
+		<-ticker.C
+	}
+}
+
+func (c *OffersCollector) refresh() {
+	ctx := context.Background()
+	s := &offersSnapshot{offers: make(map[string][]vastai.Offer)}
+	for _, gpuName := range c.machines.gpuNames() {
+		offers, err := c.client.Offers(ctx, gpuName)
+		if err != nil {
+			log.Printf("Failed to fetch offers of %s: %s", gpuName, err)
+			s.offers[gpuName] = nil
+			continue
+		}
+		// Keep an empty result distinguishable from a failed one.
+		s.offers[gpuName] = append([]vastai.Offer{}, offers.Offers...)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshot = s
+}
+
+func (c *OffersCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, metric := range c.metrics {
+		ch <- metric
+	}
+}
+
+func (c *OffersCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.RLock()
+	s := c.snapshot
+	c.mu.RUnlock()
+	if s == nil {
+		return
+	}
+
+	groups := make(map[offerGroup][]vastai.Offer)
+	for gpuName, offers := range s.offers {
+		success := 0.0
+		if offers != nil {
+			success = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["offers_scrape_success"], prometheus.GaugeValue, success, gpuName)
+		for _, offer := range offers {
+			// The search matches GPU names loosely, only count exact matches.
+			if offer.GpuName != gpuName || offer.NumGpus <= 0 {
+				continue
+			}
+			group := offerGroup{gpuName: gpuName, verification: offer.Verification}
+			groups[group] = append(groups[group], offer)
+		}
+	}
+
+	for group, offers := range groups {
+		c.collectOfferGroup(ch, group, offers)
+	}
+}
+
+func (c *OffersCollector) collectOfferGroup(ch chan<- prometheus.Metric, group offerGroup, offers []vastai.Offer) {
+	var rentedGpus, availableGpus int
+	var prices, dlperfs stats.Float64Data
+	for _, offer := range offers {
+		if offer.Rented {
+			rentedGpus += offer.NumGpus
+		} else {
+			availableGpus += offer.NumGpus
+		}
+		prices = append(prices, offer.DphBase/float64(offer.NumGpus))
+		dlperfs = append(dlperfs, offer.Dlperf/float64(offer.NumGpus))
+	}
+
+	ch <- prometheus.MustNewConstMetric(c.metrics["offers"], prometheus.GaugeValue, float64(len(offers)), group.gpuName, group.verification)
+	ch <- prometheus.MustNewConstMetric(c.metrics["offer_gpus"], prometheus.GaugeValue, float64(rentedGpus), group.gpuName, group.verification, strconv.FormatBool(true))
+	ch <- prometheus.MustNewConstMetric(c.metrics["offer_gpus"], prometheus.GaugeValue, float64(availableGpus), group.gpuName, group.verification, strconv.FormatBool(false))
+	ch <- prometheus.MustNewConstSummary(c.metrics["offer_gpu_price"], uint64(len(prices)), sum(prices), quantiles(prices, 0, 0.5, 1), group.gpuName, group.verification)
+	ch <- prometheus.MustNewConstSummary(c.metrics["offer_dlperf"], uint64(len(dlperfs)), sum(dlperfs), quantiles(dlperfs, 0, 0.25, 0.5, 0.75, 1), group.gpuName, group.verification)
+}
+
+func sum(data stats.Float64Data) float64 {
+	total, _ := stats.Sum(data)
+	return total
+}
+
+// quantiles returns the given quantiles of data, which must not be empty.
+func quantiles(data stats.Float64Data, qs ...float64) map[float64]float64 {
+	result := make(map[float64]float64, len(qs))
+	for _, q := range qs {
+		if q == 0.5 {
+			result[q], _ = stats.Median(data)
+		} else {
+			result[q], _ = stats.PercentileNearestRank(data, q*100)
+		}
+	}
+	return result
+}
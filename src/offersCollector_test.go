@@ -0,0 +1,25 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestOffersCollector(t *testing.T) {
+	dir := filepath.Join("testdata", "full")
+	machines := newFixtureCollector(t, dir, CollectorOptions{})
+	offers := NewOffersCollector(newTestClient(t, newFixtureServer(t, dir)), machines)
+	offers.refresh()
+
+	compareWithGolden(t, offers, filepath.Join(dir, "offers.prom"))
+
+	problems, err := testutil.CollectAndLint(offers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Errorf("%s: %s", problem.Metric, problem.Text)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// Names of the Vast.ai endpoints, as used in the endpoint label.
+const (
+	endpointMachineEarnings = "machine_earnings"
+	endpointMachines        = "machines"
+	endpointAccount         = "account"
+	endpointInstances       = "instances"
+	endpointInvoices        = "invoices"
+	endpointInvoiceHistory  = "invoice_history"
+)
+
+var endpoints = []string{endpointMachineEarnings, endpointMachines, endpointAccount, endpointInstances, endpointInvoices}
+
+// now returns the time that snapshots are taken at. Tests replace it to
+// get reproducible output.
+var now = time.Now
+
+// snapshot holds everything fetched from Vast.ai during one refresh.
+// It is never modified once published, so Collect can render it while
+// the next refresh is in progress. Data of failed endpoints is nil.
+type snapshot struct {
+	time time.Time
+	// earnings holds the earnings of the period Vast.ai reports by
+	// default, nil unless accountState.unrangedEarnings is set.
+	earnings *vastai.MachineEarningsAPI
+	machines *vastai.MachinesAPI
+	account  *vastai.AccountAPI
+	results  map[string]fetchResult
+	// windowEarnings holds the earnings of each window that was fetched
+	// successfully, by window name.
+	windowEarnings map[string]*vastai.MachineEarningsAPI
+	// freshEarnings is the first earnings window fetched rather than
+	// taken from the cache, for the current balance when earnings is nil.
+	freshEarnings *vastai.MachineEarningsAPI
+	// machineMetadata labels the earnings of each machine ever seen, by
+	// machine ID. It is carried over from the previous refresh if the
+	// machines endpoint failed.
+	machineMetadata map[int]machineMetadata
+
+	instances *vastai.InstancesAPI
+	// lastPayout is the latest payout in the last payoutLookback, nil if
+	// there is none or invoices were never fetched successfully. It is
+	// carried over from the previous refresh if the invoices endpoint
+	// failed.
+	lastPayout *vastai.Invoice
+	// instanceCosts is the cost of each instance accumulated since the
+	// exporter started, by instance ID.
+	instanceCosts map[int]float64
+	// utilisation is the rolling utilisation of each machine, by machine ID.
+	utilisation map[int]machineUtilisation
+	// rentalEvents counts the rental events detected since the exporter
+	// started.
+	rentalEvents map[rentalCounterKey]float64
+	// liveness is whether each known machine is up, by machine ID. It is
+	// carried over from the previous refresh if the machines endpoint
+	// failed, and nil if it never succeeded.
+	liveness map[int]machineLiveness
+	// invoiceHistory holds the invoice counters of the account, nil if
+	// they are disabled.
+	invoiceHistory *invoiceAccount
+}
+
+// fetchResult records the outcome of fetching one endpoint.
+type fetchResult struct {
+	err      error
+	duration time.Duration
+}
+
+// accountState holds the latest snapshot of one account.
+type accountState struct {
+	name   string
+	client *vastai.Client
+
+	// gracePeriod is how long machines missing from the machines response
+	// are still reported as down.
+	gracePeriod time.Duration
+	windows     []earningsWindow
+	// unrangedEarnings fetches the earnings of the period Vast.ai reports
+	// by default, which only legacy names, daily earnings and collectors
+	// without windows need.
+	unrangedEarnings bool
+
+	// Only used by refresh.
+	instanceCosts instanceCostTracker
+	liveness      machineLivenessTracker
+	payouts       payoutTracker
+	directory     machineDirectory
+	windowCache   map[string]cachedWindow
+	utilisation   *utilisationTracker
+	events        *rentalEvents
+	notifier      *Notifier      // nil if notifications are disabled
+	invoices      *invoiceLedger // nil if the invoice counters are disabled
+
+	mu          sync.RWMutex
+	snapshot    *snapshot
+	lastSuccess time.Time
+}
+
+func (a *accountState) current() (s *snapshot, lastSuccess time.Time) {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.snapshot, a.lastSuccess
+}
+
+// Run refreshes all accounts immediately and then once per interval.
+// It never returns.
+func (c *VastCollector) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		c.refresh()
+		<-ticker.C
+	}
+}
+
+// refresh fetches all accounts concurrently. A failing account does not
+// affect the snapshots of the others.
+func (c *VastCollector) refresh() {
+	var wg sync.WaitGroup
+	for _, state := range c.accounts {
+		wg.Add(1)
+		go func(state *accountState) {
+			defer wg.Done()
+			state.refresh()
+		}(state)
+	}
+	wg.Wait()
+	if err := c.utilisation.save(now()); err != nil {
+		log.Printf("Failed to save utilisation history: %s", err)
+	}
+	if c.invoices != nil {
+		if err := c.invoices.save(); err != nil {
+			log.Printf("Failed to save invoice history: %s", err)
+		}
+	}
+	c.readyOnce.Do(func() { close(c.ready) })
+}
+
+// gpuNames returns the distinct GPU models of the machines of all accounts.
+func (c *VastCollector) gpuNames() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, state := range c.accounts {
+		s, _ := state.current()
+		if s == nil || s.machines == nil {
+			continue
+		}
+		for _, machine := range s.machines.Machines {
+			if machine.GpuName != "" && !seen[machine.GpuName] {
+				seen[machine.GpuName] = true
+				names = append(names, machine.GpuName)
+			}
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (a *accountState) refresh() {
+	ctx := context.Background()
+	start := time.Now()
+	s := &snapshot{
+		time:           now(),
+		results:        make(map[string]fetchResult, len(endpoints)+len(a.windows)),
+		windowEarnings: make(map[string]*vastai.MachineEarningsAPI, len(a.windows)),
+	}
+	if a.unrangedEarnings {
+		s.results[endpointMachineEarnings] = a.timeFetch(endpointMachineEarnings, func() (err error) {
+			s.earnings, err = a.client.MachineEarnings(ctx)
+			return err
+		})
+	}
+	for _, window := range a.windows {
+		a.fetchWindow(ctx, s, window)
+	}
+	s.results[endpointMachines] = a.timeFetch(endpointMachines, func() (err error) {
+		s.machines, err = a.client.Machines(ctx)
+		return err
+	})
+	s.results[endpointAccount] = a.timeFetch(endpointAccount, func() (err error) {
+		s.account, err = a.client.Account(ctx)
+		return err
+	})
+	s.results[endpointInstances] = a.timeFetch(endpointInstances, func() (err error) {
+		s.instances, err = a.client.Instances(ctx)
+		return err
+	})
+	s.results[endpointInvoices] = a.timeFetch(endpointInvoices, func() (err error) {
+		s.lastPayout, err = a.payouts.update(ctx, a.client, s.time)
+		return err
+	})
+	if a.invoices != nil {
+		s.results[endpointInvoiceHistory] = a.timeFetch(endpointInvoiceHistory, func() (err error) {
+			s.invoiceHistory, err = a.invoices.sync(ctx, a.name, a.client, s.time)
+			return err
+		})
+	}
+	if s.instances != nil {
+		s.instanceCosts = a.instanceCosts.update(s.time, s.instances.Instances)
+	}
+	if s.machines != nil {
+		s.utilisation = a.utilisation.record(a.name, s.time, s.machines.Machines)
+		s.rentalEvents = a.events.observe(a.name, s.time, s.machines.Machines)
+		s.liveness = a.liveness.update(s.time, a.gracePeriod, s.machines.Machines)
+		s.machineMetadata = a.directory.update(s.machines.Machines)
+	} else {
+		s.liveness = a.liveness.current()
+		s.machineMetadata = a.directory.current()
+	}
+	log.Printf("Refreshed Vast.ai data of account %s in %s", a.name, time.Since(start).Round(time.Millisecond))
+
+	a.mu.Lock()
+	a.snapshot = s
+	if s.ok() {
+		a.lastSuccess = s.time
+	}
+	a.mu.Unlock()
+
+	// Alerts are only queued here, Notifier.Run sends them.
+	if a.notifier != nil && s.machines != nil {
+		a.notifier.observe(a.name, s.time, s.machines.Machines)
+	}
+}
+
+// currentEarnings returns the earnings response to take the current
+// balance from, nil if there is none.
+func (s *snapshot) currentEarnings() *vastai.MachineEarningsAPI {
+	if s.earnings != nil {
+		return s.earnings
+	}
+	return s.freshEarnings
+}
+
+// ok reports whether every endpoint was fetched successfully.
+func (s *snapshot) ok() bool {
+	for _, result := range s.results {
+		if result.err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (a *accountState) timeFetch(endpoint string, fetch func() error) fetchResult {
+	start := time.Now()
+	err := fetch()
+	if err != nil {
+		log.Printf("Failed to fetch %s of account %s: %s", endpoint, a.name, err)
+	}
+	return fetchResult{err: err, duration: time.Since(start)}
+}
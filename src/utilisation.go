@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"prometheus-vastai/src/vastai"
+)
+
+// The occupancy history of each GPU is kept as one bucket of sample counts
+// per hour, for as long as the longest utilisation window.
+const (
+	utilisationBucket  = time.Hour
+	utilisationBuckets = 30 * 24
+)
+
+// utilisationWindow is a period that utilisation ratios are exported for,
+// ending at the time of the latest refresh.
+type utilisationWindow struct {
+	name  string
+	hours int
+}
+
+var utilisationWindows = []utilisationWindow{
+	{"24h", 24},
+	{"7d", 7 * 24},
+	{"30d", 30 * 24},
+}
+
+// occupancyBucket counts the occupancy samples of a GPU in one hour.
+type occupancyBucket struct {
+	hour    int64 // hours since the UNIX epoch
+	samples [gpuUnknown + 1]int
+}
+
+// occupancyRing is a ring buffer of the hourly buckets of a GPU.
+type occupancyRing [utilisationBuckets]occupancyBucket
+
+func (r *occupancyRing) add(hour int64, state gpuState) {
+	bucket := &r[hour%utilisationBuckets]
+	if bucket.hour != hour {
+		*bucket = occupancyBucket{hour: hour}
+	}
+	bucket.samples[state]++
+}
+
+// latest returns the hour of the newest bucket.
+func (r *occupancyRing) latest() int64 {
+	var latest int64
+	for _, bucket := range r {
+		if bucket.hour > latest {
+			latest = bucket.hour
+		}
+	}
+	return latest
+}
+
+// gpuKey identifies a GPU across accounts.
+type gpuKey struct {
+	account   string
+	machineID int
+	gpu       int
+}
+
+// machineUtilisation holds the utilisation of a machine in each window.
+type machineUtilisation map[string]windowUtilisation
+
+type windowUtilisation struct {
+	// ratios is the fraction of known samples in each rented state.
+	ratios map[gpuState]float64
+	// coverage is the fraction of hours of the window with samples.
+	coverage float64
+}
+
+// utilisationTracker keeps the occupancy history of all GPUs of all
+// accounts and optionally persists it to a file, so that utilisation
+// survives restarts of the exporter.
+type utilisationTracker struct {
+	path string
+
+	mu   sync.Mutex
+	gpus map[gpuKey]*occupancyRing
+}
+
+func newUtilisationTracker(path string) *utilisationTracker {
+	return &utilisationTracker{path: path, gpus: make(map[gpuKey]*occupancyRing)}
+}
+
+// record adds one occupancy sample of every GPU of machines, taken at t,
+// and returns the utilisation of each machine by machine ID.
+func (t *utilisationTracker) record(account string, at time.Time, machines []vastai.Machine) map[int]machineUtilisation {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	hour := at.Unix() / int64(utilisationBucket/time.Second)
+
+	result := make(map[int]machineUtilisation, len(machines))
+	for _, machine := range machines {
+		var rings []*occupancyRing
+		for gpu, state := range parseOccupancy(machine.GpuOccupancy, machine.NumGpus) {
+			key := gpuKey{account: account, machineID: machine.MachineID, gpu: gpu}
+			ring, ok := t.gpus[key]
+			if !ok {
+				ring = &occupancyRing{}
+				t.gpus[key] = ring
+			}
+			ring.add(hour, state)
+			rings = append(rings, ring)
+		}
+		result[machine.MachineID] = utilisation(rings, hour)
+	}
+	return result
+}
+
+// utilisation sums the samples of rings in each window ending at hour.
+func utilisation(rings []*occupancyRing, hour int64) machineUtilisation {
+	result := make(machineUtilisation, len(utilisationWindows))
+	for _, window := range utilisationWindows {
+		var samples [gpuUnknown + 1]int
+		covered := make(map[int64]bool)
+		for _, ring := range rings {
+			for _, bucket := range ring {
+				if bucket.hour <= hour-int64(window.hours) || bucket.hour > hour {
+					continue
+				}
+				covered[bucket.hour] = true
+				for state, n := range bucket.samples {
+					samples[state] += n
+				}
+			}
+		}
+
+		known := 0
+		for state, n := range samples {
+			if gpuState(state) != gpuUnknown {
+				known += n
+			}
+		}
+		if known == 0 {
+			continue
+		}
+		ratios := make(map[gpuState]float64, 3)
+		for _, state := range []gpuState{gpuReserved, gpuOnDemand, gpuInterruptible} {
+			ratios[state] = float64(samples[state]) / float64(known)
+		}
+		result[window.name] = windowUtilisation{
+			ratios:   ratios,
+			coverage: float64(len(covered)) / float64(window.hours),
+		}
+	}
+	return result
+}
+
+// utilisationFile is the format of the file the history is persisted to.
+type utilisationFile struct {
+	GPUs []utilisationFileGPU `json:"gpus"`
+}
+
+type utilisationFileGPU struct {
+	Account   string                  `json:"account"`
+	MachineID int                     `json:"machine_id"`
+	GPU       int                     `json:"gpu"`
+	Buckets   []utilisationFileBucket `json:"buckets"`
+}
+
+type utilisationFileBucket struct {
+	Hour int64 `json:"hour"`
+	// Samples counts samples by state name, so that the file stays valid
+	// if states are added.
+	Samples map[string]int `json:"samples"`
+}
+
+// load reads the history from the file, if there is one.
+func (t *utilisationTracker) load() error {
+	if t.path == "" {
+		return nil
+	}
+	data, err := ioutil.ReadFile(t.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var file utilisationFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", t.path, err)
+	}
+
+	states := make(map[string]gpuState, len(gpuStates))
+	for _, state := range gpuStates {
+		states[state.String()] = state
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, gpu := range file.GPUs {
+		ring := &occupancyRing{}
+		for _, bucket := range gpu.Buckets {
+			b := &ring[bucket.Hour%utilisationBuckets]
+			if b.hour > bucket.Hour {
+				continue
+			}
+			*b = occupancyBucket{hour: bucket.Hour}
+			for name, n := range bucket.Samples {
+				if state, ok := states[name]; ok {
+					b.samples[state] = n
+				}
+			}
+		}
+		t.gpus[gpuKey{account: gpu.Account, machineID: gpu.MachineID, gpu: gpu.GPU}] = ring
+	}
+	return nil
+}
+
+// save writes the history to the file, dropping GPUs that have not been
+// seen for longer than the longest window.
+func (t *utilisationTracker) save(at time.Time) error {
+	if t.path == "" {
+		return nil
+	}
+	hour := at.Unix() / int64(utilisationBucket/time.Second)
+
+	var file utilisationFile
+	t.mu.Lock()
+	for key, ring := range t.gpus {
+		if ring.latest() <= hour-utilisationBuckets {
+			delete(t.gpus, key)
+			continue
+		}
+		gpu := utilisationFileGPU{Account: key.account, MachineID: key.machineID, GPU: key.gpu}
+		for _, bucket := range ring {
+			if bucket.hour <= hour-utilisationBuckets {
+				continue
+			}
+			samples := make(map[string]int)
+			for state, n := range bucket.samples {
+				if n > 0 {
+					samples[gpuState(state).String()] = n
+				}
+			}
+			gpu.Buckets = append(gpu.Buckets, utilisationFileBucket{Hour: bucket.hour, Samples: samples})
+		}
+		file.GPUs = append(file.GPUs, gpu)
+	}
+	t.mu.Unlock()
+
+	data, err := json.Marshal(file)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(t.path, data)
+}
+
+// writeFileAtomic writes data to a temporary file first and then renames it
+// to path, so that a crash never leaves a truncated file behind.
+func writeFileAtomic(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// collectUtilisation emits the utilisation of the machines of s.
+func (c *VastCollector) collectUtilisation(ch chan<- prometheus.Metric, account string, s *snapshot) {
+	for _, machine := range s.machines.Machines {
+		labels := []string{account, strconv.Itoa(machine.MachineID), machine.Hostname}
+		for _, window := range utilisationWindows {
+			u, ok := s.utilisation[machine.MachineID][window.name]
+			if !ok {
+				continue
+			}
+			for state, ratio := range u.ratios {
+				ch <- prometheus.MustNewConstMetric(c.metrics["machine_utilisation"], prometheus.GaugeValue, ratio, append(labels, state.String(), window.name)...)
+			}
+			ch <- prometheus.MustNewConstMetric(c.metrics["machine_utilisation_coverage"], prometheus.GaugeValue, u.coverage, append(labels, window.name)...)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"prometheus-vastai/src/vastai"
+)
+
+func machineWithOccupancy(occupancy string) []vastai.Machine {
+	return []vastai.Machine{{MachineID: 1, NumGpus: 2, GpuOccupancy: occupancy}}
+}
+
+func TestUtilisationWindows(t *testing.T) {
+	tracker := newUtilisationTracker("")
+	start := testTime.Add(-48 * time.Hour)
+
+	// Two days ago both GPUs were rented on demand, then one of them
+	// for the last day, with an unparseable sample in between.
+	tracker.record("a", start, machineWithOccupancy("D D"))
+	tracker.record("a", start.Add(time.Hour), machineWithOccupancy("D D"))
+	tracker.record("a", testTime.Add(-2*time.Hour), machineWithOccupancy("? ?"))
+	tracker.record("a", testTime.Add(-time.Hour), machineWithOccupancy("D x"))
+	got := tracker.record("a", testTime, machineWithOccupancy("I x"))[1]
+
+	day := got["24h"]
+	if r := day.ratios[gpuOnDemand]; r != 0.25 {
+		t.Errorf("24h on-demand ratio = %v, want 0.25", r)
+	}
+	if r := day.ratios[gpuInterruptible]; r != 0.25 {
+		t.Errorf("24h interruptible ratio = %v, want 0.25", r)
+	}
+	if r := day.ratios[gpuReserved]; r != 0 {
+		t.Errorf("24h reserved ratio = %v, want 0", r)
+	}
+	if c := day.coverage; c != 3.0/24 {
+		t.Errorf("24h coverage = %v, want 3/24", c)
+	}
+
+	week := got["7d"]
+	if r := week.ratios[gpuOnDemand]; r != 5.0/8 {
+		t.Errorf("7d on-demand ratio = %v, want 5/8", r)
+	}
+	if c := week.coverage; c != 5.0/(7*24) {
+		t.Errorf("7d coverage = %v, want 5/168", c)
+	}
+}
+
+func TestUtilisationUnknownOnly(t *testing.T) {
+	got := newUtilisationTracker("").record("a", testTime, machineWithOccupancy(""))[1]
+	if len(got) != 0 {
+		t.Errorf("got utilisation %v without known samples, want none", got)
+	}
+}
+
+func TestUtilisationRingWrapsAround(t *testing.T) {
+	tracker := newUtilisationTracker("")
+	tracker.record("a", testTime.Add(-utilisationBuckets*utilisationBucket), machineWithOccupancy("R R"))
+	got := tracker.record("a", testTime, machineWithOccupancy("x x"))[1]
+	if r := got["30d"].ratios[gpuReserved]; r != 0 {
+		t.Errorf("30d reserved ratio = %v, want 0 after the sample expired", r)
+	}
+}
+
+func TestUtilisationPersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utilisation.json")
+
+	tracker := newUtilisationTracker(path)
+	if err := tracker.load(); err != nil {
+		t.Fatalf("loading a missing file: %s", err)
+	}
+	tracker.record("a", testTime.Add(-time.Hour), machineWithOccupancy("R R"))
+	// A GPU of another machine that has not been seen for too long.
+	tracker.record("a", testTime.Add(-31*24*time.Hour), []vastai.Machine{{MachineID: 2, NumGpus: 1, GpuOccupancy: "x"}})
+	if err := tracker.save(testTime); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := newUtilisationTracker(path)
+	if err := restarted.load(); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.gpus) != 2 {
+		t.Errorf("loaded %d GPUs, want the 2 recently seen ones", len(restarted.gpus))
+	}
+	got := restarted.record("a", testTime, machineWithOccupancy("x x"))[1]
+	if r := got["24h"].ratios[gpuReserved]; r != 0.5 {
+		t.Errorf("24h reserved ratio after restart = %v, want 0.5", r)
+	}
+}
+
+func TestUtilisationCorruptFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "utilisation.json")
+	if err := ioutil.WriteFile(path, []byte(`{"gpus": [`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := newUtilisationTracker(path).load(); err == nil {
+		t.Error("expected an error for a truncated file")
+	}
+}
@@ -1,788 +1,882 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"strconv"
-	"strings"
+	"sync"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-)
 
-var (
-    gpuOccupancyGauge = prometheus.NewGaugeVec(
-        prometheus.GaugeOpts{
-            Name: "vastai_machine_gpu_occupancy",
-            Help: "GPU occupancy state per machine and GPU number.",
-        },
-        []string{"machine_id", "gpu"},
-    )
+	"prometheus-vastai/src/vastai"
 )
 
-type machineEarningsAPI struct {
-	Summary struct {
-		TotalGpu  float64 `json:"total_gpu"`
-		TotalStor float64 `json:"total_stor"`
-		TotalBwu  float64 `json:"total_bwu"`
-		TotalBwd  float64 `json:"total_bwd"`
-	} `json:"summary"`
-	Current struct {
-		Balance    float64 `json:"balance"`
-		ServiceFee float64 `json:"service_fee"`
-		Total      float64 `json:"total"`
-		Credit     float64 `json:"credit"`
-	} `json:"current"`
-	PerMachine []struct {
-		MachineID int     `json:"machine_id"`
-		GpuEarn   float64 `json:"gpu_earn"`
-		StoEarn   float64 `json:"sto_earn"`
-		BwuEarn   float64 `json:"bwu_earn"`
-		BwdEarn   float64 `json:"bwd_earn"`
-	} `json:"per_machine"`
-	PerDay []struct {
-		Day     int     `json:"day"`
-		GpuEarn float64 `json:"gpu_earn"`
-		StoEarn float64 `json:"sto_earn"`
-		BwuEarn float64 `json:"bwu_earn"`
-		BwdEarn float64 `json:"bwd_earn"`
-	} `json:"per_day"`
-}
-
-type MachinesAPI struct {
-    Machines []struct {
-        MachineID                     int         `json:"machine_id"`
-        Hostname                      string      `json:"hostname"`
-        Geolocation                   string      `json:"geolocation"`
-        Timeout                       float64     `json:"timeout"`
-        MoboName                      string      `json:"mobo_name"`
-        NumGpus                       int         `json:"num_gpus"`
-        TotalFlops                    float64     `json:"total_flops"`
-        GpuName                       string      `json:"gpu_name"`
-        GpuRAM                        int         `json:"gpu_ram"`
-        GpuMaxCurTemp                 float64     `json:"gpu_max_cur_temp"`
-        GpuLanes                      int         `json:"gpu_lanes"`
-        GpuMemBw                      float64     `json:"gpu_mem_bw"`
-        BwNvlink                      float64     `json:"bw_nvlink"`
-        PcieBw                        float64     `json:"pcie_bw"`
-        PciGen                        float64     `json:"pci_gen"`
-        CPUName                       string      `json:"cpu_name"`
-        CPURAM                        int         `json:"cpu_ram"`
-        CPUCores                      int         `json:"cpu_cores"`
-        Listed                        bool        `json:"listed"`
-        StartDate                     float64     `json:"start_date"` // UNIX timestamp
-        EndDate                       float64     `json:"end_date"`   // UNIX timestamp
-        ListedMinGpuCount             int         `json:"listed_min_gpu_count"`
-        ListedGpuCost                 float64     `json:"listed_gpu_cost"`
-        ListedStorageCost             float64     `json:"listed_storage_cost"`
-        ListedInetUpCost              float64     `json:"listed_inet_up_cost"`
-        ListedInetDownCost            float64     `json:"listed_inet_down_cost"`
-        MinBidPrice                   float64     `json:"min_bid_price"`
-        GpuOccupancy                  string      `json:"gpu_occupancy"`
-        BidGpuCost                    float64     `json:"bid_gpu_cost"`
-        BidImage                      string      `json:"bid_image"`
-        BidImageArgs                  []string    `json:"bid_image_args"`
-        BidImageArgsStr               string      `json:"bid_image_args_str"`
-        DiskSpace                     int         `json:"disk_space"`
-        MaxDiskSpace                  int         `json:"max_disk_space"`
-        AllocDiskSpace                int         `json:"alloc_disk_space"`
-        AvailDiskSpace                int         `json:"avail_disk_space"`
-        DiskName                      string      `json:"disk_name"`
-        DiskBw                        float64     `json:"disk_bw"`
-        InetUp                        float64     `json:"inet_up"`
-        InetDown                      float64     `json:"inet_down"`
-        EarnHour                      float64     `json:"earn_hour"`
-        EarnDay                       float64     `json:"earn_day"`
-        Verification                  string      `json:"verification"`
-        ErrorDescription              string      `json:"error_description"`
-        CurrentRentalsRunning         int         `json:"current_rentals_running"`
-        CurrentRentalsRunningOnDemand int         `json:"current_rentals_running_on_demand"`
-        CurrentRentalsResident        int         `json:"current_rentals_resident"`
-        CurrentRentalsOnDemand        int         `json:"current_rentals_on_demand"`
-        Reliability2                  float64     `json:"reliability2"`
-        DirectPortCount               int         `json:"direct_port_count"`
-        PublicIPAddr                  string      `json:"public_ipaddr"`
-        Clients                       []Client    `json:"clients"` // New nested struct
-    } `json:"machines"`
+// Account is a Vast.ai account to export metrics for. Its name is used
+// as the account label.
+type Account struct {
+	Name   string
+	Client *vastai.Client
 }
 
-type Client struct {
-    ID                int     `json:"id"`
-    LastUpdate        float64 `json:"last_update"`        // Changed to float64 to handle decimal values
-    LastProc          float64 `json:"last_proc"`          // Changed to float64 to handle decimal values
-    Type              string  `json:"type"`
-    Label             string  `json:"label"`
-    ClientID          int     `json:"client_id"`
-    HostID            int     `json:"host_id"`
-    CreatedAt         float64 `json:"created_at"`         // Changed to float64
-    DeletedAt         float64 `json:"deleted_at"`         // Changed to float64
-    StartDate         float64 `json:"start_date"`         // Changed to float64
-    EndDate           float64 `json:"end_date"`           // Changed to float64
-    ClientRunTime     float64 `json:"client_run_time"`    // Changed to float64 to handle decimal values
-    ClientStopTime    float64 `json:"client_stop_time"`   // Changed to float64 to handle decimal values
-    ClientUnloadTime  float64 `json:"client_unload_time"` // Changed to float64 to handle decimal values
-    HostRunTime       float64 `json:"host_run_time"`      // Changed to float64 to handle decimal values
-    HostStopTime      float64 `json:"host_stop_time"`     // Changed to float64 to handle decimal values
-    HostUnloadTime    float64 `json:"host_unload_time"`   // Changed to float64 to handle decimal values
-    NextTransDate     float64 `json:"next_trans_date"`    // Changed to float64
-    MaxSpend          float64 `json:"max_spend"`
-    CreatedFrom       int     `json:"created_from"`
-    BundleID          int     `json:"bundle_id"`
-    LastBillup        float64 `json:"last_billup"`        // Changed to float64
-    IsSystem          float64 `json:"is_system"`
-    EarnSec           int     `json:"earn_sec"`
-    EarnMin           int     `json:"earn_min"`
-    EarnHour          int     `json:"earn_hour"`
-    EarnDay           int     `json:"earn_day"`
-    LossSec           int     `json:"loss_sec"`
-    LossMin           int     `json:"loss_min"`
-    LossHour          int     `json:"loss_hour"`
-    LossDay           int     `json:"loss_day"`
-    MinBidPrice       float64 `json:"min_bid_price"`
-    Dlperf            float64 `json:"dlperf"`
+// CollectorOptions configures a VastCollector.
+type CollectorOptions struct {
+	// LegacyNames additionally exports metrics under the names used before
+	// the metric names were made consistent, for dashboards that have not
+	// been migrated yet.
+	LegacyNames bool
+	// UtilisationFile is where the occupancy history that utilisation is
+	// computed from is persisted. If empty, it is only kept in memory.
+	UtilisationFile string
+	// MachineGracePeriod is how long a machine that no longer appears in
+	// the machines response is reported as down before it is forgotten.
+	MachineGracePeriod time.Duration
+	// Notifier is sent the machines of every refresh, if not nil.
+	Notifier *Notifier
+	// EarningsWindows are the windows that earnings are fetched and
+	// exported for, on top of the period Vast.ai reports by default.
+	EarningsWindows []earningsWindow
+	// DailyEarnings exports the earnings of each day with a day label.
+	// The label grows without bound, so the backfill command is the better
+	// way to get earnings history.
+	DailyEarnings bool
+	// InvoiceHistoryStart is where paging through the invoice history of
+	// each account starts. If zero, the invoice counters are disabled.
+	InvoiceHistoryStart time.Time
+	// InvoiceStateFile is where the invoice counters and their high-water
+	// marks are persisted. If empty, they are only kept in memory.
+	InvoiceStateFile string
 }
 
-
 type VastCollector struct {
-	apiKey  string
-	metrics map[string]*prometheus.Desc
+	accounts []*accountState
+	metrics  map[string]*prometheus.Desc
+	// legacyMetrics is nil unless CollectorOptions.LegacyNames is set.
+	legacyMetrics map[string]*prometheus.Desc
+	utilisation   *utilisationTracker
+	events        *rentalEvents
+	invoices      *invoiceLedger // nil if the invoice counters are disabled
+	// earningsWindows is shared with the accounts.
+	earningsWindows []earningsWindow
+
+	// ready is closed once all accounts have been refreshed for the
+	// first time.
+	ready     chan struct{}
+	readyOnce sync.Once
 }
 
-func NewVastCollector(apiKey string) *VastCollector {
-	return &VastCollector{
-		apiKey: apiKey,
+func NewVastCollector(accounts []Account, opts CollectorOptions) *VastCollector {
+	utilisation := newUtilisationTracker(opts.UtilisationFile)
+	if err := utilisation.load(); err != nil {
+		log.Printf("Failed to load utilisation history, starting afresh: %s", err)
+	}
+	events := newRentalEvents()
+	var invoices *invoiceLedger
+	if !opts.InvoiceHistoryStart.IsZero() {
+		invoices = newInvoiceLedger(opts.InvoiceStateFile, opts.InvoiceHistoryStart)
+		if err := invoices.load(); err != nil {
+			log.Printf("Failed to load invoice history, starting afresh: %s", err)
+		}
+	}
+	states := make([]*accountState, len(accounts))
+	for i, account := range accounts {
+		states[i] = &accountState{
+			name:        account.Name,
+			client:      account.Client,
+			gracePeriod: opts.MachineGracePeriod,
+			utilisation: utilisation,
+			events:      events,
+			notifier:    opts.Notifier,
+			windows:     opts.EarningsWindows,
+			invoices:    invoices,
+
+			unrangedEarnings: opts.LegacyNames || opts.DailyEarnings || len(opts.EarningsWindows) == 0,
+		}
+	}
+	var legacyMetrics map[string]*prometheus.Desc
+	if opts.LegacyNames {
+		legacyMetrics = newLegacyMetrics()
+	}
+	c := &VastCollector{
+		accounts:        states,
+		ready:           make(chan struct{}),
+		legacyMetrics:   legacyMetrics,
+		utilisation:     utilisation,
+		events:          events,
+		invoices:        invoices,
+		earningsWindows: opts.EarningsWindows,
 		metrics: map[string]*prometheus.Desc{
 			"account_balance": prometheus.NewDesc(
-				"vastai_account_balance",
-				"The current account balance of the user",
-				nil, nil,
-			),
-			"total_gpu_summary": prometheus.NewDesc(
-				"vastai_summary_total_gpu",
-				"Total GPU earnings in summary",
-				nil, nil,
-			),
-			"total_stor_summary": prometheus.NewDesc(
-				"vastai_summary_total_stor",
-				"Total storage earnings in summary",
-				nil, nil,
-			),
-			"total_bwu_summary": prometheus.NewDesc(
-				"vastai_summary_total_bwu",
-				"Total bandwidth upload earnings in summary",
-				nil, nil,
-			),
-			"total_bwd_summary": prometheus.NewDesc(
-				"vastai_summary_total_bwd",
-				"Total bandwidth download earnings in summary",
-				nil, nil,
+				"vastai_account_balance_usd",
+				"Current balance of the account",
+				[]string{"account"}, nil,
+			),
+			"account_credit": prometheus.NewDesc(
+				"vastai_account_credit_usd",
+				"Credit of the account",
+				[]string{"account"}, nil,
+			),
+			"account_payouts": prometheus.NewDesc(
+				"vastai_account_payouts_usd",
+				"Payouts of hosting earnings as reported by Vast.ai, by whether they were paid or are pending",
+				[]string{"account", "status"}, nil,
+			),
+			"account_billed": prometheus.NewDesc(
+				"vastai_account_billed_usd",
+				"Charges for rented instances as reported by Vast.ai, by whether they were verified or are expected",
+				[]string{"account", "status"}, nil,
+			),
+			"account_balance_threshold": prometheus.NewDesc(
+				"vastai_account_balance_threshold_usd",
+				"Balance below which the account is topped up, if enabled",
+				[]string{"account"}, nil,
+			),
+			"account_flag": prometheus.NewDesc(
+				"vastai_account_flag",
+				"Billing and verification flags of the account",
+				[]string{"account", "flag"}, nil,
+			),
+			"account_last_payout": prometheus.NewDesc(
+				"vastai_account_last_payout_usd",
+				"Amount of the latest payout in the last 90 days",
+				[]string{"account"}, nil,
+			),
+			"account_last_payout_timestamp": prometheus.NewDesc(
+				"vastai_account_last_payout_timestamp_seconds",
+				"UNIX timestamp of the latest payout in the last 90 days",
+				[]string{"account"}, nil,
+			),
+			"earnings": prometheus.NewDesc(
+				"vastai_earnings_usd",
+				"Earnings of all machines in the window, by type",
+				[]string{"account", "window", "type"}, nil,
 			),
 			"current_balance": prometheus.NewDesc(
-				"vastai_current_balance",
+				"vastai_current_balance_usd",
 				"Current balance",
-				nil, nil,
+				[]string{"account"}, nil,
 			),
 			"current_service_fee": prometheus.NewDesc(
-				"vastai_current_service_fee",
+				"vastai_current_service_fee_usd",
 				"Current service fee",
-				nil, nil,
+				[]string{"account"}, nil,
 			),
 			"current_total": prometheus.NewDesc(
-				"vastai_current_total",
+				"vastai_current_total_usd",
 				"Current total",
-				nil, nil,
+				[]string{"account"}, nil,
 			),
 			"current_credit": prometheus.NewDesc(
-				"vastai_current_credit",
+				"vastai_current_credit_usd",
 				"Current credit",
-				nil, nil,
-			),
-			"per_machine_gpu_earn": prometheus.NewDesc(
-				"vastai_per_machine_gpu_earn",
-				"GPU earnings per machine",
-				[]string{"machine_id"}, nil,
-			),
-			"per_machine_sto_earn": prometheus.NewDesc(
-				"vastai_per_machine_sto_earn",
-				"Storage earnings per machine",
-				[]string{"machine_id"}, nil,
-			),
-			"per_machine_bwu_earn": prometheus.NewDesc(
-				"vastai_per_machine_bwu_earn",
-				"Bandwidth upload earnings per machine",
-				[]string{"machine_id"}, nil,
-			),
-			"per_machine_bwd_earn": prometheus.NewDesc(
-				"vastai_per_machine_bwd_earn",
-				"Bandwidth download earnings per machine",
-				[]string{"machine_id"}, nil,
-			),
-			"per_day_gpu_earn": prometheus.NewDesc(
-				"vastai_per_day_gpu_earn",
-				"GPU earnings per day",
-				[]string{"day"}, nil,
-			),
-			"per_day_sto_earn": prometheus.NewDesc(
-				"vastai_per_day_sto_earn",
-				"Storage earnings per day",
-				[]string{"day"}, nil,
-			),
-			"per_day_bwu_earn": prometheus.NewDesc(
-				"vastai_per_day_bwu_earn",
-				"Bandwidth upload earnings per day",
-				[]string{"day"}, nil,
-			),
-			"per_day_bwd_earn": prometheus.NewDesc(
-				"vastai_per_day_bwd_earn",
-				"Bandwidth download earnings per day",
-				[]string{"day"}, nil,
-			),			
-			"machine_id": prometheus.NewDesc(
-				"vastai_machine_id",
-				"Machine ID",
-				nil, nil,
+				[]string{"account"}, nil,
 			),
-			"machine_timeout": prometheus.NewDesc(
-				"vastai_machine_timeout",
-				"Machine timeout",
-				nil, nil,
+			"machine_earnings": prometheus.NewDesc(
+				"vastai_machine_earnings_usd",
+				"Earnings of a machine in the window, by type; retired machines are no longer in the machines response",
+				[]string{"account", "machine_id", "hostname", "gpu_name", "retired", "window", "type"}, nil,
 			),
-			"machine_num_gpus": prometheus.NewDesc(
-				"vastai_machine_num_gpus",
+			"machine_gpus": prometheus.NewDesc(
+				"vastai_machine_gpus",
 				"Number of GPUs in the machine",
-				nil, nil,
-			),
-			"machine_gpu_name": prometheus.NewDesc(
-			"vastai_machine_gpu_name",
-			"Machine GPU Name",
-			[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname", "gpu_name"}, nil,
 			),
 			"machine_total_flops": prometheus.NewDesc(
-				"vastai_machine_total_flops",
-				"Machine total FLOPS",
-				nil, nil,
-			),
-			"machine_Listed": prometheus.NewDesc(
-				"vastai_machine_Listed",
-				"Machine Listed",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"machine_Verification": prometheus.NewDesc(
-				"vastai_machine_Verification",
-				"Machine Verification",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"machine_Reliability": prometheus.NewDesc(
-				"vastai_machine_Reliability",
-				"Machine Reliability",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"machine_InetUp": prometheus.NewDesc(
-				"vastai_machine_InetUp",
-				"Machine Inet Up",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-
-			"machine_InetDown": prometheus.NewDesc(
-				"vastai_machine_InetDown",
-				"Machine Inet Down",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			// New metrics
-			"machine_hostname": prometheus.NewDesc(
-				"vastai_machine_hostname",
-				"Machine Hostname",
-				[]string{"machine_id", "hostname"}, nil,
+				"vastai_machine_total_tflops",
+				"Total TFLOPS of the GPUs in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_timeout": prometheus.NewDesc(
+				"vastai_machine_timeout",
+				"Timeout of the machine as reported by Vast.ai",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_listed": prometheus.NewDesc(
+				"vastai_machine_listed",
+				"Whether the machine is listed on the marketplace",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_verified": prometheus.NewDesc(
+				"vastai_machine_verified",
+				"Whether the machine is verified",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_reliability": prometheus.NewDesc(
+				"vastai_machine_reliability",
+				"Reliability score of the machine, between 0 and 1",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_inet_up": prometheus.NewDesc(
+				"vastai_machine_inet_up_bytes_per_second",
+				"Measured upload bandwidth of the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_inet_down": prometheus.NewDesc(
+				"vastai_machine_inet_down_bytes_per_second",
+				"Measured download bandwidth of the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_current_rentals_running": prometheus.NewDesc(
 				"vastai_machine_current_rentals_running",
 				"Current rentals running on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_current_rentals_running_on_demand": prometheus.NewDesc(
 				"vastai_machine_current_rentals_running_on_demand",
 				"Current rentals running on demand on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_current_rentals_resident": prometheus.NewDesc(
 				"vastai_machine_current_rentals_resident",
 				"Current resident rentals on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_current_rentals_on_demand": prometheus.NewDesc(
 				"vastai_machine_current_rentals_on_demand",
 				"Current on-demand rentals on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
-			"machine_max_disk_space": prometheus.NewDesc(
-				"vastai_machine_max_disk_space",
+			"machine_disk_max": prometheus.NewDesc(
+				"vastai_machine_disk_max_bytes",
 				"Maximum disk space on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
-			"machine_alloc_disk_space": prometheus.NewDesc(
-				"vastai_machine_alloc_disk_space",
+			"machine_disk_allocated": prometheus.NewDesc(
+				"vastai_machine_disk_allocated_bytes",
 				"Allocated disk space on machine",
-				[]string{"machine_id", "hostname"}, nil,
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
-			"machine_avail_disk_space": prometheus.NewDesc(
-				"vastai_machine_avail_disk_space",
+			"machine_disk_available": prometheus.NewDesc(
+				"vastai_machine_disk_available_bytes",
 				"Available disk space on machine",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"gpu_rented_on_demand": prometheus.NewDesc(
-				"vastai_machine_gpu_rented_on_demand",
-				"Number of GPUs rented on-demand",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"gpu_rented_on_reserved": prometheus.NewDesc(
-				"vastai_machine_gpu_rented_on_reserved",
-				"Number of GPUs rented on-reserved",
-				[]string{"machine_id", "hostname"}, nil,
-			),			
-			"gpu_rented_bid_demand": prometheus.NewDesc(
-				"vastai_machine_gpu_rented_bid_demand",
-				"Number of GPUs rented bid-demand",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"gpu_idle": prometheus.NewDesc(
-				"vastai_machine_gpu_idle",
-				"Number of GPUs idle",
-				[]string{"machine_id", "hostname"}, nil,
-			),	
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpus_rented": prometheus.NewDesc(
+				"vastai_machine_gpus_rented",
+				"Number of rented GPUs, by rental type",
+				[]string{"account", "machine_id", "hostname", "type"}, nil,
+			),
+			"machine_gpus_idle": prometheus.NewDesc(
+				"vastai_machine_gpus_idle",
+				"Number of idle GPUs",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpus_unknown": prometheus.NewDesc(
+				"vastai_machine_gpus_unknown",
+				"Number of GPUs whose occupancy is missing or not understood",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpu_state": prometheus.NewDesc(
+				"vastai_machine_gpu_state",
+				"Occupancy of a GPU, 1 for its current state and 0 for the others",
+				[]string{"account", "machine_id", "hostname", "gpu", "state"}, nil,
+			),
+			"machine_utilisation": prometheus.NewDesc(
+				"vastai_machine_utilisation_ratio",
+				"Fraction of GPU occupancy samples of the machine in the window in which GPUs were rented, by rental type; samples with unknown occupancy are left out",
+				[]string{"account", "machine_id", "hostname", "type", "window"}, nil,
+			),
+			"machine_utilisation_coverage": prometheus.NewDesc(
+				"vastai_machine_utilisation_coverage_ratio",
+				"Fraction of the hours of the window for which the exporter has occupancy samples of the machine",
+				[]string{"account", "machine_id", "hostname", "window"}, nil,
+			),
+			"machine_earnings_per_gpu": prometheus.NewDesc(
+				"vastai_machine_earnings_per_gpu_usd",
+				"Earnings of all types of the machine in the window divided by its number of GPUs",
+				[]string{"account", "machine_id", "hostname", "window"}, nil,
+			),
+			"machine_earnings_per_gpu_hour": prometheus.NewDesc(
+				"vastai_machine_earnings_per_gpu_hour_usd",
+				"Earnings of all types of the machine in the window per GPU-hour that has passed in it, rented or not",
+				[]string{"account", "machine_id", "hostname", "window"}, nil,
+			),
+			"machine_earnings_per_dlperf": prometheus.NewDesc(
+				"vastai_machine_earnings_per_dlperf_usd",
+				"Earnings of all types of the machine in the window per DLPerf point of the machine",
+				[]string{"account", "machine_id", "hostname", "window"}, nil,
+			),
+			"machine_earnings_per_tflops": prometheus.NewDesc(
+				"vastai_machine_earnings_per_tflops_usd",
+				"Earnings of all types of the machine in the window per TFLOPS of the machine",
+				[]string{"account", "machine_id", "hostname", "window"}, nil,
+			),
+			"machine_realised_gpu_price": prometheus.NewDesc(
+				"vastai_machine_realised_gpu_price_usd",
+				"Current earnings of the machine per hour divided by its rented GPUs, the effective price per GPU-hour",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_realised_price_ratio": prometheus.NewDesc(
+				"vastai_machine_realised_price_ratio",
+				"Effective price per rented GPU-hour relative to the listed on-demand price",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_idle_opportunity_cost": prometheus.NewDesc(
+				"vastai_machine_idle_opportunity_cost_per_hour_usd",
+				"Idle GPUs of the machine times its listed on-demand price per GPU-hour",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"gpu_model_earnings_per_dlperf": prometheus.NewDesc(
+				"vastai_gpu_model_earnings_per_dlperf_usd",
+				"Earnings of all types of the machines with the GPU model in the window per DLPerf point of those machines, counting only machines with a DLPerf score",
+				[]string{"account", "gpu_name", "window"}, nil,
+			),
+			"gpu_model_earnings_per_gpu_hour": prometheus.NewDesc(
+				"vastai_gpu_model_earnings_per_gpu_hour_usd",
+				"Earnings of all types of the machines with the GPU model in the window per GPU-hour that has passed in it",
+				[]string{"account", "gpu_name", "window"}, nil,
+			),
+			"gpu_model_idle_opportunity_cost": prometheus.NewDesc(
+				"vastai_gpu_model_idle_opportunity_cost_per_hour_usd",
+				"Idle GPUs of the GPU model times their listed on-demand price per GPU-hour",
+				[]string{"account", "gpu_name"}, nil,
+			),
+			"machine_up": prometheus.NewDesc(
+				"vastai_machine_up",
+				"Whether the machine is online: in the latest machines response without a timeout; machines that dropped out are reported as 0 for the grace period",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_last_seen": prometheus.NewDesc(
+				"vastai_machine_last_seen_timestamp_seconds",
+				"UNIX timestamp of the last refresh in which the machine was online, or of the latest update of its rentals if later",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machines_known": prometheus.NewDesc(
+				"vastai_machines_known",
+				"Number of machines in the machines response or missing from it for less than the grace period",
+				[]string{"account"}, nil,
+			),
+			"machines_reporting": prometheus.NewDesc(
+				"vastai_machines_reporting",
+				"Number of machines online in the latest machines response",
+				[]string{"account"}, nil,
+			),
+			"rentals_started": prometheus.NewDesc(
+				"vastai_rentals_started_total",
+				"Rental contracts that appeared on a machine since the exporter started, by rental type",
+				[]string{"account", "machine_id", "type"}, nil,
+			),
+			"rentals_ended": prometheus.NewDesc(
+				"vastai_rentals_ended_total",
+				"Rental contracts that disappeared from a machine since the exporter started, by rental type",
+				[]string{"account", "machine_id", "type"}, nil,
+			),
+			"rentals_extended": prometheus.NewDesc(
+				"vastai_rentals_extended_total",
+				"Rental contracts on a machine whose end date was moved later since the exporter started, by rental type",
+				[]string{"account", "machine_id", "type"}, nil,
+			),
+			"rental_type_changes": prometheus.NewDesc(
+				"vastai_rental_type_changes_total",
+				"Rental contracts on a machine whose type changed since the exporter started",
+				[]string{"account", "machine_id", "from", "to"}, nil,
+			),
+			"gpu_state_changes": prometheus.NewDesc(
+				"vastai_machine_gpu_state_changes_total",
+				"Changes of the occupancy of the GPUs of a machine since the exporter started",
+				[]string{"account", "machine_id", "from", "to"}, nil,
+			),
 			"machine_earn_hour": prometheus.NewDesc(
-				"vastai_machine_earn_hour",
-				"Machine earn hour",
-				[]string{"machine_id", "hostname"}, nil,
-			),	
-			"machine_ErrorDescription": prometheus.NewDesc(
-				"vastai_machine_ErrorDescription",
-				"Machine Error Description",
-				[]string{"machine_id", "hostname", "error_description"}, nil,
-			),
-			"machine_start_date": prometheus.NewDesc(
-				"vastai_machine_start_date",
-				"Start date of the machine as a UNIX timestamp",
-				[]string{"machine_id", "hostname"}, nil,
-			),
-			"machine_end_date": prometheus.NewDesc(
-				"vastai_machine_end_date",
-				"End date of the machine as a UNIX timestamp",
-				[]string{"machine_id", "hostname"}, nil,
+				"vastai_machine_earnings_per_hour_usd",
+				"Current earnings of the machine per hour",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_error": prometheus.NewDesc(
+				"vastai_machine_error",
+				"Whether the machine reports an error, with the error message as a label",
+				[]string{"account", "machine_id", "hostname", "error_description"}, nil,
+			),
+			"machine_start": prometheus.NewDesc(
+				"vastai_machine_start_timestamp_seconds",
+				"Start date of the machine's current listing as a UNIX timestamp",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_end": prometheus.NewDesc(
+				"vastai_machine_end_timestamp_seconds",
+				"End date of the machine's current listing as a UNIX timestamp",
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_listed_gpu_cost": prometheus.NewDesc(
-				"vastai_machine_listed_gpu_cost",
-				"Currently listed On-Demand Price",
-				[]string{"machine_id", "hostname"}, nil,
+				"vastai_machine_listed_gpu_cost_usd",
+				"Currently listed on-demand price per GPU-hour",
+				[]string{"account", "machine_id", "hostname"}, nil,
 			),
 			"machine_min_bid_price": prometheus.NewDesc(
-				"vastai_machine_min_bid_price",
-				"Currently listed Bid Price",
-				[]string{"machine_id", "hostname"}, nil,
-			),	
+				"vastai_machine_min_bid_price_usd",
+				"Currently listed minimum bid price per GPU-hour",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_listed_storage_cost": prometheus.NewDesc(
+				"vastai_machine_listed_storage_cost_usd",
+				"Currently listed storage price per GB-month",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_listed_inet_up_cost": prometheus.NewDesc(
+				"vastai_machine_listed_inet_up_cost_usd",
+				"Currently listed price per GB of upload traffic",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_listed_inet_down_cost": prometheus.NewDesc(
+				"vastai_machine_listed_inet_down_cost_usd",
+				"Currently listed price per GB of download traffic",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_listed_min_gpus": prometheus.NewDesc(
+				"vastai_machine_listed_min_gpus",
+				"Currently listed minimum number of GPUs per rental",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_bid_gpu_cost": prometheus.NewDesc(
+				"vastai_machine_bid_gpu_cost_usd",
+				"Price per GPU-hour of the host's own interruptible job that runs on idle GPUs",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_bid_image": prometheus.NewDesc(
+				"vastai_machine_bid_image_info",
+				"Image and arguments of the host's own interruptible job that runs on idle GPUs, value is always 1",
+				[]string{"account", "machine_id", "hostname", "image", "args"}, nil,
+			),
+			"machine_info": prometheus.NewDesc(
+				"vastai_machine_info",
+				"Hardware and location of the machine, value is always 1",
+				[]string{"account", "machine_id", "hostname", "gpu_name", "cpu_name", "mobo_name", "disk_name", "geolocation", "public_ipaddr"}, nil,
+			),
+			"machine_gpu_ram": prometheus.NewDesc(
+				"vastai_machine_gpu_ram_bytes",
+				"Memory of each GPU in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpu_memory_bandwidth": prometheus.NewDesc(
+				"vastai_machine_gpu_memory_bandwidth_bytes_per_second",
+				"Memory bandwidth of each GPU in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpu_max_temperature": prometheus.NewDesc(
+				"vastai_machine_gpu_max_temperature_celsius",
+				"Current temperature of the hottest GPU in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_gpu_pcie_lanes": prometheus.NewDesc(
+				"vastai_machine_gpu_pcie_lanes",
+				"Number of PCIe lanes of each GPU in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_pcie_generation": prometheus.NewDesc(
+				"vastai_machine_pcie_generation",
+				"PCIe generation of the GPU slots",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_pcie_bandwidth": prometheus.NewDesc(
+				"vastai_machine_pcie_bandwidth_bytes_per_second",
+				"Measured PCIe bandwidth between the CPU and each GPU",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_nvlink_bandwidth": prometheus.NewDesc(
+				"vastai_machine_nvlink_bandwidth_bytes_per_second",
+				"Measured NVLink bandwidth between the GPUs, 0 without NVLink",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_cpu_cores": prometheus.NewDesc(
+				"vastai_machine_cpu_cores",
+				"Number of CPU cores in the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_cpu_ram": prometheus.NewDesc(
+				"vastai_machine_cpu_ram_bytes",
+				"System memory of the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_disk_bandwidth": prometheus.NewDesc(
+				"vastai_machine_disk_bandwidth_bytes_per_second",
+				"Measured disk bandwidth of the machine",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"machine_direct_ports": prometheus.NewDesc(
+				"vastai_machine_direct_ports",
+				"Number of ports of the machine that can be forwarded directly to instances",
+				[]string{"account", "machine_id", "hostname"}, nil,
+			),
+			"client_info": prometheus.NewDesc(
+				"vastai_machine_client_info",
+				"Rental contract running on a machine, value is always 1",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type", "bundle_id"}, nil,
+			),
+			"client_start": prometheus.NewDesc(
+				"vastai_machine_client_start_timestamp_seconds",
+				"Start date of the rental contract as a UNIX timestamp",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_end": prometheus.NewDesc(
+				"vastai_machine_client_end_timestamp_seconds",
+				"End date of the rental contract as a UNIX timestamp",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_run_time": prometheus.NewDesc(
+				"vastai_machine_client_run_timestamp_seconds",
+				"Time the rental was last started, as a UNIX timestamp, by the client or the host",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type", "by"}, nil,
+			),
+			"client_stop_time": prometheus.NewDesc(
+				"vastai_machine_client_stop_timestamp_seconds",
+				"Time the rental was last stopped, as a UNIX timestamp, by the client or the host",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type", "by"}, nil,
+			),
+			"client_last_update": prometheus.NewDesc(
+				"vastai_machine_client_last_update_timestamp_seconds",
+				"Time the rental contract was last updated, as a UNIX timestamp",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_max_spend": prometheus.NewDesc(
+				"vastai_machine_client_max_spend_usd",
+				"Maximum amount the client may spend on the rental",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_earn_hour": prometheus.NewDesc(
+				"vastai_machine_client_earnings_per_hour_usd",
+				"Current earnings of the rental per hour",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_earn_day": prometheus.NewDesc(
+				"vastai_machine_client_earnings_per_day_usd",
+				"Current earnings of the rental per day",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_loss_hour": prometheus.NewDesc(
+				"vastai_machine_client_loss_per_hour_usd",
+				"Current loss of the rental per hour",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_loss_day": prometheus.NewDesc(
+				"vastai_machine_client_loss_per_day_usd",
+				"Current loss of the rental per day",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_min_bid_price": prometheus.NewDesc(
+				"vastai_machine_client_min_bid_price_usd",
+				"Minimum bid price of the rental per GPU-hour",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_dlperf": prometheus.NewDesc(
+				"vastai_machine_client_dlperf",
+				"DLPerf score of the rented GPUs",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"client_earn_hour_per_dlperf": prometheus.NewDesc(
+				"vastai_machine_client_earnings_per_hour_per_dlperf_usd",
+				"Earnings per hour of the rental contract per DLPerf point of the rented GPUs",
+				[]string{"account", "machine_id", "hostname", "contract_id", "type"}, nil,
+			),
+			"instance_info": prometheus.NewDesc(
+				"vastai_instance_info",
+				"Instance rented by the user, value is always 1",
+				[]string{"account", "instance_id", "machine_id", "gpu_name", "image", "type", "label"}, nil,
+			),
+			"instance_status": prometheus.NewDesc(
+				"vastai_instance_status",
+				"Current status of an instance rented by the user, value is always 1",
+				[]string{"account", "instance_id", "status"}, nil,
+			),
+			"instance_gpus": prometheus.NewDesc(
+				"vastai_instance_gpus",
+				"Number of GPUs of an instance rented by the user",
+				[]string{"account", "instance_id"}, nil,
+			),
+			"instance_cost_per_hour": prometheus.NewDesc(
+				"vastai_instance_cost_per_hour_usd",
+				"Current cost per hour of an instance rented by the user, including storage and bandwidth",
+				[]string{"account", "instance_id"}, nil,
+			),
+			"instance_cost": prometheus.NewDesc(
+				"vastai_instance_cost_usd_total",
+				"Cost of an instance rented by the user, accumulated from its hourly cost since the exporter started",
+				[]string{"account", "instance_id"}, nil,
+			),
+			"instance_uptime": prometheus.NewDesc(
+				"vastai_instance_uptime_seconds",
+				"Seconds since an instance rented by the user was started",
+				[]string{"account", "instance_id"}, nil,
+			),
+			"snapshot_age": prometheus.NewDesc(
+				"vastai_snapshot_age_seconds",
+				"Seconds since the data currently served was fetched from Vast.ai",
+				[]string{"account"}, nil,
+			),
+			"last_successful_refresh": prometheus.NewDesc(
+				"vastai_last_successful_refresh_timestamp_seconds",
+				"UNIX timestamp of the last refresh in which all Vast.ai endpoints were fetched",
+				[]string{"account"}, nil,
+			),
+			"scrape_success": prometheus.NewDesc(
+				"vastai_scrape_success",
+				"Whether the last fetch of a Vast.ai endpoint succeeded",
+				[]string{"account", "endpoint"}, nil,
+			),
+			"scrape_duration": prometheus.NewDesc(
+				"vastai_scrape_duration_seconds",
+				"Duration of the last fetch of a Vast.ai endpoint",
+				[]string{"account", "endpoint"}, nil,
+			),
 		},
 	}
+	if opts.DailyEarnings {
+		c.metrics["daily_earnings"] = prometheus.NewDesc(
+			"vastai_daily_earnings_usd",
+			"Earnings of all machines per day, by type",
+			[]string{"account", "day", "type"}, nil,
+		)
+	}
+	if invoices != nil {
+		c.metrics["invoice_amount"] = prometheus.NewDesc(
+			"vastai_invoice_amount_usd_total",
+			"Amount of all invoices counted since the start of the invoice history, by invoice type",
+			[]string{"account", "type"}, nil,
+		)
+		c.metrics["invoices"] = prometheus.NewDesc(
+			"vastai_invoices_total",
+			"Number of invoices counted since the start of the invoice history, by invoice type",
+			[]string{"account", "type"}, nil,
+		)
+		c.metrics["invoice_history_synced"] = prometheus.NewDesc(
+			"vastai_invoice_history_synced_timestamp_seconds",
+			"UNIX timestamp up to which the invoice history has been counted",
+			[]string{"account"}, nil,
+		)
+	}
+	return c
 }
 
-func (c *VastCollector) fetchAccountBalance(ch chan<- prometheus.Metric) {
-    balanceURL := fmt.Sprintf("https://console.vast.ai/api/v0/users/current?api_key=%s", c.apiKey)
-    req, err := http.NewRequest("GET", balanceURL, nil)
-    if err != nil {
-        log.Printf("Failed to create request: %s", err)
-        return
-    }
-    req.Header.Set("Accept", "application/json")
-
-    client := &http.Client{}
-    resp, err := client.Do(req)
-    if err != nil {
-        log.Printf("Failed to make request: %s", err)
-        return
-    }
-    defer resp.Body.Close()
-
-    var accountData struct {
-        Balance float64 `json:"balance"`
-    }
-
-    err = json.NewDecoder(resp.Body).Decode(&accountData)
-    if err != nil {
-        log.Printf("Failed to decode JSON response: %s", err)
-        return
-    }
-
-    // Add the balance metric to Prometheus
-    ch <- prometheus.MustNewConstMetric(
-        c.metrics["account_balance"],
-        prometheus.GaugeValue,
-        accountData.Balance,
-    )
-}
+// Values of the type label of earnings metrics.
+var earningTypes = []string{"gpu", "storage", "upload", "download"}
 
-func (c *VastCollector) fetchMachineEarnings(ch chan<- prometheus.Metric) {
-	earningsURL := fmt.Sprintf("https://console.vast.ai/api/v0/users/me/machine-earnings?api_key=%s", c.apiKey)
-	req, err := http.NewRequest("GET", earningsURL, nil)
-	if err != nil {
-		log.Fatalf("Failed to create request: %s", err)
-		return
+// collectEarnings emits desc once per earning type, with the type label
+// appended to labels.
+func collectEarnings(ch chan<- prometheus.Metric, desc *prometheus.Desc, gpu, storage, upload, download float64, labels ...string) {
+	for i, value := range []float64{gpu, storage, upload, download} {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, append(labels, earningTypes[i])...)
 	}
-	req.Header.Set("Accept", "application/json")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalf("Failed to make request: %s", err)
-		return
-	}
-	defer resp.Body.Close()
+func (c *VastCollector) collectCurrentEarnings(ch chan<- prometheus.Metric, account string, earningsData *vastai.MachineEarningsAPI) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["current_balance"], prometheus.GaugeValue, earningsData.Current.Balance, account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["current_service_fee"], prometheus.GaugeValue, earningsData.Current.ServiceFee, account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["current_total"], prometheus.GaugeValue, earningsData.Current.Total, account)
+	ch <- prometheus.MustNewConstMetric(c.metrics["current_credit"], prometheus.GaugeValue, earningsData.Current.Credit, account)
+}
 
-	var earningsData machineEarningsAPI
-	err = json.NewDecoder(resp.Body).Decode(&earningsData)
-	if err != nil {
-		log.Fatalf("Failed to decode JSON response: %s", err)
+func (c *VastCollector) collectDailyEarnings(ch chan<- prometheus.Metric, account string, earningsData *vastai.MachineEarningsAPI) {
+	desc, ok := c.metrics["daily_earnings"]
+	if !ok {
 		return
 	}
-
-	ch <- prometheus.MustNewConstMetric(c.metrics["total_gpu_summary"], prometheus.GaugeValue, earningsData.Summary.TotalGpu)
-	ch <- prometheus.MustNewConstMetric(c.metrics["total_stor_summary"], prometheus.GaugeValue, earningsData.Summary.TotalStor)
-	ch <- prometheus.MustNewConstMetric(c.metrics["total_bwu_summary"], prometheus.GaugeValue, earningsData.Summary.TotalBwu)
-	ch <- prometheus.MustNewConstMetric(c.metrics["total_bwd_summary"], prometheus.GaugeValue, earningsData.Summary.TotalBwd)
-	ch <- prometheus.MustNewConstMetric(c.metrics["current_balance"], prometheus.GaugeValue, earningsData.Current.Balance)
-	ch <- prometheus.MustNewConstMetric(c.metrics["current_service_fee"], prometheus.GaugeValue, earningsData.Current.ServiceFee)
-	ch <- prometheus.MustNewConstMetric(c.metrics["current_total"], prometheus.GaugeValue, earningsData.Current.Total)
-	ch <- prometheus.MustNewConstMetric(c.metrics["current_credit"], prometheus.GaugeValue, earningsData.Current.Credit)
-
-	for _, machine := range earningsData.PerMachine {
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_machine_gpu_earn"], prometheus.GaugeValue, machine.GpuEarn, strconv.Itoa(machine.MachineID))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_machine_sto_earn"], prometheus.GaugeValue, machine.StoEarn, strconv.Itoa(machine.MachineID))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_machine_bwu_earn"], prometheus.GaugeValue, machine.BwuEarn, strconv.Itoa(machine.MachineID))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_machine_bwd_earn"], prometheus.GaugeValue, machine.BwdEarn, strconv.Itoa(machine.MachineID))
-	}
-
 	for _, day := range earningsData.PerDay {
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_day_gpu_earn"], prometheus.GaugeValue, day.GpuEarn, strconv.Itoa(day.Day))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_day_sto_earn"], prometheus.GaugeValue, day.StoEarn, strconv.Itoa(day.Day))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_day_bwu_earn"], prometheus.GaugeValue, day.BwuEarn, strconv.Itoa(day.Day))
-		ch <- prometheus.MustNewConstMetric(c.metrics["per_day_bwd_earn"], prometheus.GaugeValue, day.BwdEarn, strconv.Itoa(day.Day))
+		collectEarnings(ch, desc, day.GpuEarn, day.StoEarn, day.BwuEarn, day.BwdEarn,
+			account, formatDay(day.Day))
 	}
 }
 
-
-func parseGpuOccupancy(occupancy string, machineID string, hostname string, ch chan<- prometheus.Metric) {
-    // Remove spaces from the occupancy string
-    occupancyNoSpaces := strings.ReplaceAll(occupancy, " ", "")
-    
-    for i, char := range occupancyNoSpaces {
-        state := 0
-        switch char {
-        case 'R':
-            state = 3
-        case 'D':
-            state = 2
-        case 'I':
-            state = 1
-        case 'x':
-            state = 0
-        }
-        // Emitting the GPU occupancy metric with corrected index
-        ch <- prometheus.MustNewConstMetric(
-            prometheus.NewDesc("vastai_machine_gpu_occupancy", "GPU occupancy state per machine and GPU number.", []string{"machine_id", "Hostname", "gpu"}, nil),
-            prometheus.GaugeValue,
-            float64(state),
-            machineID,
-			hostname,
-            strconv.Itoa(i), // i now correctly represents the GPU index
-        )
-    }
+// formatDay formats a day number of the earnings API, counted in days
+// since the UNIX epoch, as a date.
+func formatDay(day int) string {
+	return time.Unix(int64(day)*24*60*60, 0).UTC().Format("2006-01-02")
 }
 
-
-func (c *VastCollector) fetchMachines(ch chan<- prometheus.Metric) {
-	machinesURL := fmt.Sprintf("https://console.vast.ai/api/v0/machines/?api_key=%s", c.apiKey)
-	req, err := http.NewRequest("GET", machinesURL, nil)
-	if err != nil {
-		log.Fatalf("Failed to create request: %s", err)
-		return
+func (c *VastCollector) collectMachines(ch chan<- prometheus.Metric, account string, machinesAPI *vastai.MachinesAPI) {
+	for _, machine := range machinesAPI.Machines {
+		machineID := strconv.Itoa(machine.MachineID)
+		labels := []string{account, machineID, machine.Hostname}
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpus"], prometheus.GaugeValue, float64(machine.NumGpus), append(labels, machine.GpuName)...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_total_flops"], prometheus.GaugeValue, machine.TotalFlops, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_timeout"], prometheus.GaugeValue, machine.Timeout, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed"], prometheus.GaugeValue, boolToFloat(machine.Listed), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_verified"], prometheus.GaugeValue, boolToFloat(machine.Verification == "verified"), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_reliability"], prometheus.GaugeValue, machine.Reliability2, labels...)
+		// Bandwidth is reported in Mbit/s.
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_inet_up"], prometheus.GaugeValue, machine.InetUp*1e6/8, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_inet_down"], prometheus.GaugeValue, machine.InetDown*1e6/8, labels...)
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_current_rentals_running"], prometheus.GaugeValue, float64(machine.CurrentRentalsRunning), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_current_rentals_running_on_demand"], prometheus.GaugeValue, float64(machine.CurrentRentalsRunningOnDemand), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_current_rentals_resident"], prometheus.GaugeValue, float64(machine.CurrentRentalsResident), labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_current_rentals_on_demand"], prometheus.GaugeValue, float64(machine.CurrentRentalsOnDemand), labels...)
+
+		// Disk space is reported in GB.
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_disk_max"], prometheus.GaugeValue, float64(machine.MaxDiskSpace)*1e9, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_disk_allocated"], prometheus.GaugeValue, float64(machine.AllocDiskSpace)*1e9, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_disk_available"], prometheus.GaugeValue, float64(machine.AvailDiskSpace)*1e9, labels...)
+
+		c.collectOccupancy(ch, labels, parseOccupancy(machine.GpuOccupancy, machine.NumGpus))
+
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_earn_hour"], prometheus.GaugeValue, machine.EarnHour, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_error"], prometheus.GaugeValue, boolToFloat(machine.ErrorDescription != ""), append(labels, machine.ErrorDescription)...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_start"], prometheus.GaugeValue, machine.StartDate, labels...)
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_end"], prometheus.GaugeValue, machine.EndDate, labels...)
+
+		c.collectMachineListing(ch, labels, machine)
+		c.collectMachineHardware(ch, labels, machine)
+		for _, client := range machine.Clients {
+			c.collectMachineClient(ch, account, machineID, machine.Hostname, client)
+		}
 	}
-	req.Header.Set("Accept", "application/json")
+}
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		log.Fatalf("Failed to make request: %s", err)
-		return
+// collectOccupancy emits the state of each GPU of a machine and the
+// number of GPUs in each state. labels are the account, machine ID and
+// hostname.
+func (c *VastCollector) collectOccupancy(ch chan<- prometheus.Metric, labels []string, states []gpuState) {
+	for i, current := range states {
+		gpuLabels := append(labels, strconv.Itoa(i))
+		for _, state := range gpuStates {
+			ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpu_state"], prometheus.GaugeValue, boolToFloat(state == current), append(gpuLabels, state.String())...)
+		}
 	}
-	defer resp.Body.Close()
 
-	var machinesAPI MachinesAPI
-	err = json.NewDecoder(resp.Body).Decode(&machinesAPI)
-	if err != nil {
-		log.Fatalf("Failed to decode JSON response: %s", err)
-		return
+	counts := countStates(states)
+	for _, state := range []gpuState{gpuReserved, gpuOnDemand, gpuInterruptible} {
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpus_rented"], prometheus.GaugeValue, float64(counts[state]), append(labels, state.String())...)
 	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpus_idle"], prometheus.GaugeValue, float64(counts[gpuIdle]), labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpus_unknown"], prometheus.GaugeValue, float64(counts[gpuUnknown]), labels...)
+}
 
-	for _, machine := range machinesAPI.Machines {
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_listed_gpu_cost"],
-			prometheus.GaugeValue,
-			float64(machine.ListedGpuCost),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_min_bid_price"],
-			prometheus.GaugeValue,
-			float64(machine.MinBidPrice),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_start_date"],
-			prometheus.GaugeValue,
-			float64(machine.StartDate)*1000,  // Use float64 for UNIX timestamps
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_end_date"],
-			prometheus.GaugeValue,
-			float64(machine.EndDate)*1000,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_id", "Machine ID", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			float64(machine.MachineID),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_timeout", "Machine timeout", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			float64(machine.Timeout),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_num_gpus", "Number of GPUs in the machine", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			float64(machine.NumGpus),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_gpu_name", "Type and total number of GPUs in the machine", []string{"machine_id", "gpu_name", "hostname"}, nil),
-			prometheus.GaugeValue,
-			float64(machine.NumGpus),
-			strconv.Itoa(machine.MachineID),
-			machine.GpuName,
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_total_flops", "Machine total FLOPS", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			machine.TotalFlops,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		var listedValue float64
-		if machine.Listed {
-			listedValue = 1.0
-		} else {
-			listedValue = 0.0
-		}	
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_Listed", "Machine Listed", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			listedValue,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)	
-		var verificationValue float64
-		if machine.Verification == "verified" {
-			verificationValue = 1.0
-		} else {
-			verificationValue = 0.0
-		}	
-		
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_Verification", "Machine Verification", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			verificationValue,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,			
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_Reliability", "Machine Reliability", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			machine.Reliability2,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vastai_machine_InetUp", "Machine Inet Up", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			machine.InetUp,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_InetDown", "Machine Inet Down", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			machine.InetDown,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-
-		ch <- prometheus.MustNewConstMetric(
-			prometheus.NewDesc("vast_machine_hostname", "Machine Hostname", []string{"machine_id", "hostname"}, nil),
-			prometheus.GaugeValue,
-			1.0,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_current_rentals_running"], 
-			prometheus.GaugeValue,
-			float64(machine.CurrentRentalsRunning),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_current_rentals_running_on_demand"],
-			prometheus.GaugeValue,
-			float64(machine.CurrentRentalsRunningOnDemand),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_current_rentals_resident"],
-			prometheus.GaugeValue,
-			float64(machine.CurrentRentalsResident),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_current_rentals_on_demand"],
-			prometheus.GaugeValue,
-			float64(machine.CurrentRentalsOnDemand),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_max_disk_space"],
-			prometheus.GaugeValue,
-			float64(machine.MaxDiskSpace),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_alloc_disk_space"],
-			prometheus.GaugeValue,
-			float64(machine.AllocDiskSpace),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_avail_disk_space"],
-			prometheus.GaugeValue,
-			float64(machine.AvailDiskSpace),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
+// collectMachineListing emits the prices and conditions a machine is
+// listed with. labels are the account, machine ID and hostname.
+func (c *VastCollector) collectMachineListing(ch chan<- prometheus.Metric, labels []string, machine vastai.Machine) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed_gpu_cost"], prometheus.GaugeValue, machine.ListedGpuCost, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed_storage_cost"], prometheus.GaugeValue, machine.ListedStorageCost, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed_inet_up_cost"], prometheus.GaugeValue, machine.ListedInetUpCost, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed_inet_down_cost"], prometheus.GaugeValue, machine.ListedInetDownCost, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_listed_min_gpus"], prometheus.GaugeValue, float64(machine.ListedMinGpuCount), labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_min_bid_price"], prometheus.GaugeValue, machine.MinBidPrice, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_bid_gpu_cost"], prometheus.GaugeValue, machine.BidGpuCost, labels...)
+	if machine.BidImage != "" {
+		ch <- prometheus.MustNewConstMetric(c.metrics["machine_bid_image"], prometheus.GaugeValue, 1, append(labels, machine.BidImage, machine.BidImageArgsStr)...)
+	}
+}
 
-		gpuRentedOnDemand := strings.Count(machine.GpuOccupancy, "D")
-		gpuRentedReserved := strings.Count(machine.GpuOccupancy, "R")
-		gpuRentedBidDemand := strings.Count(machine.GpuOccupancy, "I")
-		gpuIdle := strings.Count(machine.GpuOccupancy, "x")
-		
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["gpu_rented_on_demand"],
-			prometheus.GaugeValue,
-			float64(gpuRentedOnDemand),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["gpu_rented_on_reserved"],
-			prometheus.GaugeValue,
-			float64(gpuRentedReserved),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,  // Add this if the metric description includes the hostname
-			
-		)
-		if err != nil {
-    		log.Printf("Error creating gpu_rented_on_reserved metric: %s", err)
-    			continue  // Skip this metric and handle error appropriately
-		}
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["gpu_rented_bid_demand"],
-			prometheus.GaugeValue,
-			float64(gpuRentedBidDemand),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["gpu_idle"],
-			prometheus.GaugeValue,
-			float64(gpuIdle),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)	
-
-		gpuOccupancy := machine.GpuOccupancy // Ensure this field exists and is correctly named
-        machineID := strconv.Itoa(machine.MachineID) // Convert machine ID to string
-
-        parseGpuOccupancy(gpuOccupancy, machineID, machine.Hostname, ch)
-
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_earn_hour"],
-			prometheus.GaugeValue,
-			float64(machine.EarnHour),
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-		)
-		var errorDescription string
-		var errorValue float64
-		if machine.ErrorDescription == "" {
-			errorDescription = ""
-			errorValue = 1.0 // No error
-		} else {
-			errorDescription = fmt.Sprintf("%v", machine.ErrorDescription)
-			if errorDescription != "" {
-				errorValue = 10.0 // There is an error
-			} else {
-				errorValue = 1.0 // No error
-			}
-		}
+// collectMachineHardware emits the hardware attributes of a machine.
+// labels are the account, machine ID and hostname.
+func (c *VastCollector) collectMachineHardware(ch chan<- prometheus.Metric, labels []string, machine vastai.Machine) {
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_info"], prometheus.GaugeValue, 1,
+		append(labels, machine.GpuName, machine.CPUName, machine.MoboName, machine.DiskName, machine.Geolocation, machine.PublicIPAddr)...)
+	// Memory is reported in MiB, disk bandwidth in MB/s and the other
+	// bandwidths in GB/s.
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpu_ram"], prometheus.GaugeValue, float64(machine.GpuRAM)*1024*1024, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpu_memory_bandwidth"], prometheus.GaugeValue, machine.GpuMemBw*1e9, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpu_max_temperature"], prometheus.GaugeValue, machine.GpuMaxCurTemp, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_gpu_pcie_lanes"], prometheus.GaugeValue, float64(machine.GpuLanes), labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_pcie_generation"], prometheus.GaugeValue, machine.PciGen, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_pcie_bandwidth"], prometheus.GaugeValue, machine.PcieBw*1e9, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_nvlink_bandwidth"], prometheus.GaugeValue, machine.BwNvlink*1e9, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_cpu_cores"], prometheus.GaugeValue, float64(machine.CPUCores), labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_cpu_ram"], prometheus.GaugeValue, float64(machine.CPURAM)*1024*1024, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_disk_bandwidth"], prometheus.GaugeValue, machine.DiskBw*1e6, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["machine_direct_ports"], prometheus.GaugeValue, float64(machine.DirectPortCount), labels...)
+}
 
-		// Send ErrorDescription to Prometheus
-		ch <- prometheus.MustNewConstMetric(
-			c.metrics["machine_ErrorDescription"],
-			prometheus.GaugeValue,
-			errorValue,
-			strconv.Itoa(machine.MachineID),
-			machine.Hostname,
-			errorDescription,
-		)
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
 	}
+	return 0
 }
 
+// collectMachineClient emits the metrics of one rental contract on a machine.
+func (c *VastCollector) collectMachineClient(ch chan<- prometheus.Metric, account string, machineID string, hostname string, client vastai.MachineClient) {
+	labels := []string{account, machineID, hostname, strconv.Itoa(client.ID), client.Type}
 
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_info"], prometheus.GaugeValue, 1, append(labels, strconv.Itoa(client.BundleID))...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_start"], prometheus.GaugeValue, client.StartDate, labels...)
+	if client.EndDate > 0 {
+		ch <- prometheus.MustNewConstMetric(c.metrics["client_end"], prometheus.GaugeValue, client.EndDate, labels...)
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_run_time"], prometheus.GaugeValue, client.ClientRunTime, append(labels, "client")...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_run_time"], prometheus.GaugeValue, client.HostRunTime, append(labels, "host")...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_stop_time"], prometheus.GaugeValue, client.ClientStopTime, append(labels, "client")...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_stop_time"], prometheus.GaugeValue, client.HostStopTime, append(labels, "host")...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_last_update"], prometheus.GaugeValue, client.LastUpdate, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_max_spend"], prometheus.GaugeValue, client.MaxSpend, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_earn_hour"], prometheus.GaugeValue, client.EarnHour, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_earn_day"], prometheus.GaugeValue, client.EarnDay, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_loss_hour"], prometheus.GaugeValue, client.LossHour, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_loss_day"], prometheus.GaugeValue, client.LossDay, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_min_bid_price"], prometheus.GaugeValue, client.MinBidPrice, labels...)
+	ch <- prometheus.MustNewConstMetric(c.metrics["client_dlperf"], prometheus.GaugeValue, client.Dlperf, labels...)
+	if client.Dlperf > 0 {
+		ch <- prometheus.MustNewConstMetric(c.metrics["client_earn_hour_per_dlperf"], prometheus.GaugeValue, client.EarnHour/client.Dlperf, labels...)
+	}
+}
 
+// Events returns a handler listing the most recent rental events as JSON.
+func (c *VastCollector) Events() http.Handler {
+	return c.events
+}
 
 func (c *VastCollector) Describe(ch chan<- *prometheus.Desc) {
 	for _, metric := range c.metrics {
 		ch <- metric
 	}
+	for _, metric := range c.legacyMetrics {
+		ch <- metric
+	}
 }
 
 func (c *VastCollector) Collect(ch chan<- prometheus.Metric) {
-	c.fetchMachineEarnings(ch)
-	c.fetchMachines(ch)
-	c.fetchAccountBalance(ch)  
-	// Call other fetch methods as you add them
+	for _, state := range c.accounts {
+		c.collectAccount(ch, state)
+	}
+}
+
+func (c *VastCollector) collectAccount(ch chan<- prometheus.Metric, state *accountState) {
+	s, lastSuccess := state.current()
+	if s == nil {
+		// Nothing fetched yet.
+		return
+	}
+	account := state.name
+
+	if current := s.currentEarnings(); current != nil {
+		c.collectCurrentEarnings(ch, account, current)
+	}
+	if s.earnings != nil {
+		c.collectDailyEarnings(ch, account, s.earnings)
+	}
+	c.collectEarningsWindows(ch, account, s)
+	if s.machines != nil {
+		c.collectMachines(ch, account, s.machines)
+		c.collectUtilisation(ch, account, s)
+		c.collectRentalEvents(ch, account, s)
+		c.collectEfficiency(ch, account, s)
+	}
+	if s.liveness != nil {
+		c.collectLiveness(ch, account, s)
+	}
+	if s.account != nil {
+		c.collectAccountDetails(ch, account, s.account)
+	}
+	if s.lastPayout != nil {
+		c.collectLastPayout(ch, account, s.lastPayout)
+	}
+	if s.invoiceHistory != nil {
+		c.collectInvoices(ch, account, s)
+	}
+	if s.instances != nil {
+		c.collectInstances(ch, account, s)
+	}
+	if c.legacyMetrics != nil {
+		c.collectLegacy(ch, account, s)
+	}
+
+	for endpoint, result := range s.results {
+		success := 0.0
+		if result.err == nil {
+			success = 1.0
+		}
+		ch <- prometheus.MustNewConstMetric(c.metrics["scrape_success"], prometheus.GaugeValue, success, account, endpoint)
+		ch <- prometheus.MustNewConstMetric(c.metrics["scrape_duration"], prometheus.GaugeValue, result.duration.Seconds(), account, endpoint)
+	}
+	ch <- prometheus.MustNewConstMetric(c.metrics["snapshot_age"], prometheus.GaugeValue, now().Sub(s.time).Seconds(), account)
+	if !lastSuccess.IsZero() {
+		ch <- prometheus.MustNewConstMetric(c.metrics["last_successful_refresh"], prometheus.GaugeValue, float64(lastSuccess.Unix()), account)
+	}
 }
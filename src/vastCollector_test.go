@@ -0,0 +1,363 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/expfmt"
+
+	"prometheus-vastai/src/vastai"
+)
+
+var update = flag.Bool("update", false, "rewrite the expected metrics in testdata")
+
+const testAPIKey = "0123456789abcdef-test-api-key"
+
+// testTime is the time snapshots are taken at in tests.
+var testTime = time.Unix(1696003260, 0)
+
+// fixtureFiles maps API paths to the files in a testdata directory that
+// hold recorded responses. Paths without a file get a 404.
+var fixtureFiles = map[string]string{
+	"/machines/":                 "machines.json",
+	"/users/me/machine-earnings": "machine_earnings.json",
+	"/users/current":             "account.json",
+	"/instances":                 "instances.json",
+	"/users/me/invoices":         "invoices.json",
+	"/bundles/":                  "offers.json",
+}
+
+// volatileMetrics depend on timing and are left out of comparisons.
+var volatileMetrics = map[string]bool{
+	"vastai_scrape_duration_seconds": true,
+}
+
+func TestMain(m *testing.M) {
+	flag.Parse()
+	now = func() time.Time { return testTime }
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+// newFixtureServer serves the recorded responses in dir.
+func newFixtureServer(t *testing.T, dir string) *httptest.Server {
+	return newSwitchingFixtureServer(t, &dir)
+}
+
+// newSwitchingFixtureServer serves the recorded responses in *dir, which
+// may be changed between requests to simulate changes in the API data.
+func newSwitchingFixtureServer(t *testing.T, dir *string) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		file, ok := fixtureFiles[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		data, err := ioutil.ReadFile(filepath.Join(*dir, file))
+		if os.IsNotExist(err) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if r.URL.Path == "/bundles/" {
+			data = filterRentedOffers(t, data, r.URL.Query().Get("q"))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(data)
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+// filterRentedOffers applies the rented filter of the offers search query
+// q to the recorded offers in data, which hold rented and unrented offers.
+func filterRentedOffers(t *testing.T, data []byte, q string) []byte {
+	var query struct {
+		Rented *struct {
+			Eq bool `json:"eq"`
+		} `json:"rented"`
+	}
+	var offers vastai.OffersAPI
+	if err := json.Unmarshal([]byte(q), &query); err != nil || query.Rented == nil {
+		return data
+	}
+	if err := json.Unmarshal(data, &offers); err != nil {
+		t.Error(err)
+		return data
+	}
+	result := vastai.OffersAPI{Offers: []vastai.Offer{}}
+	for _, offer := range offers.Offers {
+		if offer.Rented == query.Rented.Eq {
+			result.Offers = append(result.Offers, offer)
+		}
+	}
+	data, _ = json.Marshal(result)
+	return data
+}
+
+func newTestClient(t *testing.T, server *httptest.Server) *vastai.Client {
+	client, err := vastai.NewClient(vastai.Config{BaseURL: server.URL, APIKey: testAPIKey})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+// newFixtureCollector returns a collector for a single account served from
+// the recorded responses in dir, refreshed once.
+func newFixtureCollector(t *testing.T, dir string, opts CollectorOptions) *VastCollector {
+	server := newFixtureServer(t, dir)
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}}, opts)
+	collector.refresh()
+	return collector
+}
+
+// newLaterFixtureCollector returns a collector that was refreshed with the
+// full fixtures and an hour later with the ones in testdata/later, which
+// start, end, extend and retype rentals.
+func newLaterFixtureCollector(t *testing.T, opts CollectorOptions) *VastCollector {
+	dir := filepath.Join("testdata", "full")
+	server := newSwitchingFixtureServer(t, &dir)
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}}, opts)
+	now = func() time.Time { return testTime.Add(-time.Hour) }
+	collector.refresh()
+	now = func() time.Time { return testTime }
+	dir = filepath.Join("testdata", "later")
+	collector.refresh()
+	return collector
+}
+
+// compareWithGolden compares everything collector exports, except volatile
+// metrics, with the text exposition in file.
+func compareWithGolden(t *testing.T, collector prometheus.Collector, file string) {
+	t.Helper()
+	// The pedantic registry fails to gather metrics whose descriptor was
+	// not sent by Describe.
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(collector)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	var got bytes.Buffer
+	for _, family := range families {
+		if volatileMetrics[family.GetName()] {
+			continue
+		}
+		names = append(names, family.GetName())
+		if _, err := expfmt.MetricFamilyToText(&got, family); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sort.Strings(names)
+
+	if *update {
+		if err := ioutil.WriteFile(file, got.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	expected, err := os.Open(file)
+	if err != nil {
+		t.Fatalf("%s; run the tests with -update to create it", err)
+	}
+	defer expected.Close()
+	if err := testutil.GatherAndCompare(registry, expected, names...); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCollector(t *testing.T) {
+	for _, scenario := range []string{
+		"full",      // everything as recorded from the API
+		"empty",     // no machines, no earnings, no instances
+		"malformed", // broken JSON, an HTML error page and mistyped fields
+		"partial",   // missing fields, a short occupancy string and a 404
+	} {
+		scenario := scenario
+		t.Run(scenario, func(t *testing.T) {
+			dir := filepath.Join("testdata", scenario)
+			collector := newFixtureCollector(t, dir, CollectorOptions{EarningsWindows: testEarningsWindows})
+			compareWithGolden(t, collector, filepath.Join(dir, "metrics.prom"))
+		})
+	}
+}
+
+func TestCollectorLegacyNames(t *testing.T) {
+	dir := filepath.Join("testdata", "full")
+	collector := newFixtureCollector(t, dir, CollectorOptions{LegacyNames: true})
+	compareWithGolden(t, collector, filepath.Join(dir, "legacy.prom"))
+}
+
+func TestCollectorLint(t *testing.T) {
+	problems, err := testutil.CollectAndLint(newFixtureCollector(t, filepath.Join("testdata", "full"), CollectorOptions{EarningsWindows: testEarningsWindows}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, problem := range problems {
+		t.Errorf("%s: %s", problem.Metric, problem.Text)
+	}
+}
+
+// TestDescribe checks that Describe sends exactly the descriptors of the
+// metrics that are collected, given responses that have every field set
+// and change between refreshes.
+func TestDescribe(t *testing.T) {
+	collector := newLaterFixtureCollector(t, CollectorOptions{
+		LegacyNames:         true,
+		EarningsWindows:     testEarningsWindows,
+		DailyEarnings:       true,
+		InvoiceHistoryStart: testTime.Add(-30 * 24 * time.Hour),
+	})
+
+	described := make(map[*prometheus.Desc]bool)
+	descs := make(chan *prometheus.Desc)
+	go func() {
+		collector.Describe(descs)
+		close(descs)
+	}()
+	for desc := range descs {
+		if described[desc] {
+			t.Errorf("%s described twice", desc)
+		}
+		described[desc] = true
+	}
+
+	collected := make(map[*prometheus.Desc]bool)
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		collector.Collect(metrics)
+		close(metrics)
+	}()
+	for metric := range metrics {
+		if !described[metric.Desc()] {
+			t.Errorf("%s collected but not described", metric.Desc())
+		}
+		collected[metric.Desc()] = true
+	}
+	for desc := range described {
+		if !collected[desc] {
+			t.Errorf("%s described but never collected", desc)
+		}
+	}
+}
+
+func TestCollectorBeforeFirstRefresh(t *testing.T) {
+	server := newFixtureServer(t, filepath.Join("testdata", "full"))
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}}, CollectorOptions{})
+	if n := testutil.CollectAndCount(collector); n != 0 {
+		t.Errorf("got %d metrics before the first refresh, want none", n)
+	}
+}
+
+// TestAPIKeyNotLeaked checks that the API key is never sent in a URL and
+// never shows up in log output or metrics, even when the server echoes it.
+func TestAPIKeyNotLeaked(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(ioutil.Discard)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.String(), testAPIKey) {
+			t.Errorf("API key sent in URL %s", r.URL)
+		}
+		if got := r.Header.Get("Authorization"); got != "Bearer "+testAPIKey {
+			t.Errorf("Authorization header = %q, want bearer token", got)
+		}
+		switch r.URL.Path {
+		case "/machines/":
+			w.Write([]byte(`{"machines": [{"machine_id": 1, "hostname": "host1", "gpu_occupancy": "D x"}]}`))
+		case "/users/me/machine-earnings":
+			// A misbehaving proxy redirecting to an unreachable URL with the key in it.
+			http.Redirect(w, r, "http://127.0.0.1:0/?api_key="+testAPIKey, http.StatusFound)
+		case "/users/current":
+			http.Error(w, "bad gateway "+testAPIKey, http.StatusBadGateway)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	collector := NewVastCollector([]Account{{Name: "test", Client: newTestClient(t, server)}}, CollectorOptions{})
+	collector.refresh()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collector)
+	recorder := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	metrics, err := ioutil.ReadAll(recorder.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(logs.String(), "Failed to fetch "+endpointMachineEarnings) {
+		t.Errorf("expected the failed earnings fetch to be logged, got:\n%s", logs.String())
+	}
+	if strings.Contains(logs.String(), testAPIKey) {
+		t.Errorf("API key found in log output:\n%s", logs.String())
+	}
+	if !bytes.Contains(metrics, []byte("vastai_machine_gpu_state")) {
+		t.Errorf("expected machine metrics, got:\n%s", metrics)
+	}
+	if bytes.Contains(metrics, []byte(testAPIKey)) {
+		t.Errorf("API key found in metrics:\n%s", metrics)
+	}
+}
+
+// TestAccountsAreIsolated checks that a failing account does not affect
+// the metrics of another one.
+func TestAccountsAreIsolated(t *testing.T) {
+	good := newFixtureServer(t, filepath.Join("testdata", "full"))
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer bad.Close()
+
+	collector := NewVastCollector([]Account{
+		{Name: "good", Client: newTestClient(t, good)},
+		{Name: "bad", Client: newTestClient(t, bad)},
+	}, CollectorOptions{})
+	collector.refresh()
+
+	expected := `
+# HELP vastai_account_balance_usd Current balance of the account
+# TYPE vastai_account_balance_usd gauge
+vastai_account_balance_usd{account="good"} 512.77
+# HELP vastai_scrape_success Whether the last fetch of a Vast.ai endpoint succeeded
+# TYPE vastai_scrape_success gauge
+vastai_scrape_success{account="bad",endpoint="account"} 0
+vastai_scrape_success{account="bad",endpoint="instances"} 0
+vastai_scrape_success{account="bad",endpoint="invoices"} 0
+vastai_scrape_success{account="bad",endpoint="machine_earnings"} 0
+vastai_scrape_success{account="bad",endpoint="machines"} 0
+vastai_scrape_success{account="good",endpoint="account"} 1
+vastai_scrape_success{account="good",endpoint="instances"} 1
+vastai_scrape_success{account="good",endpoint="invoices"} 1
+vastai_scrape_success{account="good",endpoint="machine_earnings"} 1
+vastai_scrape_success{account="good",endpoint="machines"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected),
+		"vastai_account_balance_usd", "vastai_scrape_success"); err != nil {
+		t.Error(err)
+	}
+}
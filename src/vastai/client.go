@@ -0,0 +1,247 @@
+// Package vastai is a minimal client for the Vast.ai REST API.
+package vastai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBaseURL is the Vast.ai API used when Config.BaseURL is empty.
+const DefaultBaseURL = "https://console.vast.ai/api/v0/"
+
+// Config configures a Client. Only APIKey is required.
+type Config struct {
+	// BaseURL is the API root, e.g. DefaultBaseURL or a caching proxy.
+	BaseURL string
+	APIKey  string
+	// Transport is used for all requests; nil means http.DefaultTransport.
+	Transport http.RoundTripper
+	// Timeout limits each request; zero means no timeout.
+	Timeout   time.Duration
+	UserAgent string
+}
+
+// Client fetches data from the Vast.ai API. It is safe for concurrent use.
+type Client struct {
+	baseURL    *url.URL
+	userAgent  string
+	httpClient *http.Client
+
+	mu     sync.RWMutex
+	apiKey string
+}
+
+// NewClient returns a Client for cfg.
+func NewClient(cfg Config) (*Client, error) {
+	if cfg.BaseURL == "" {
+		cfg.BaseURL = DefaultBaseURL
+	}
+	// Without a trailing slash, relative paths would replace the last
+	// segment of the base URL instead of being appended to it.
+	if !strings.HasSuffix(cfg.BaseURL, "/") {
+		cfg.BaseURL += "/"
+	}
+	baseURL, err := url.Parse(cfg.BaseURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid base URL: %w", err)
+	}
+	if baseURL.Scheme != "http" && baseURL.Scheme != "https" {
+		return nil, fmt.Errorf("invalid base URL %q: scheme must be http or https", cfg.BaseURL)
+	}
+	return &Client{
+		baseURL:   baseURL,
+		apiKey:    cfg.APIKey,
+		userAgent: cfg.UserAgent,
+		httpClient: &http.Client{
+			Transport: cfg.Transport,
+			Timeout:   cfg.Timeout,
+		},
+	}, nil
+}
+
+// SetAPIKey replaces the API key used for subsequent requests.
+func (c *Client) SetAPIKey(apiKey string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.apiKey = apiKey
+}
+
+func (c *Client) getAPIKey() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.apiKey
+}
+
+// Machines returns the user's hosted machines.
+func (c *Client) Machines(ctx context.Context) (*MachinesAPI, error) {
+	var machines MachinesAPI
+	if err := c.get(ctx, "machines/", nil, &machines); err != nil {
+		return nil, err
+	}
+	return &machines, nil
+}
+
+// MachineEarnings returns the earnings of the user's machines.
+func (c *Client) MachineEarnings(ctx context.Context) (*MachineEarningsAPI, error) {
+	var earnings MachineEarningsAPI
+	if err := c.get(ctx, "users/me/machine-earnings", nil, &earnings); err != nil {
+		return nil, err
+	}
+	return &earnings, nil
+}
+
+// EarningsQuery restricts the machine earnings to a range of days and
+// optionally to one machine. Days are counted since the UNIX epoch, as in
+// the per_day earnings.
+type EarningsQuery struct {
+	StartDay int
+	EndDay   int
+	// MachineID is the machine to return the earnings of, 0 for all.
+	MachineID int
+}
+
+// MachineEarningsBetween returns the earnings of the user's machines in
+// the days of q, both inclusive.
+func (c *Client) MachineEarningsBetween(ctx context.Context, q EarningsQuery) (*MachineEarningsAPI, error) {
+	params := url.Values{
+		"sday": {strconv.Itoa(q.StartDay)},
+		"eday": {strconv.Itoa(q.EndDay)},
+	}
+	if q.MachineID != 0 {
+		params.Set("machid", strconv.Itoa(q.MachineID))
+	}
+	var earnings MachineEarningsAPI
+	if err := c.get(ctx, "users/me/machine-earnings", params, &earnings); err != nil {
+		return nil, err
+	}
+	return &earnings, nil
+}
+
+// Account returns the current user's account.
+func (c *Client) Account(ctx context.Context) (*AccountAPI, error) {
+	var account AccountAPI
+	if err := c.get(ctx, "users/current", nil, &account); err != nil {
+		return nil, err
+	}
+	return &account, nil
+}
+
+// Invoices returns the invoices of the user between start and end,
+// including instance charges.
+func (c *Client) Invoices(ctx context.Context, start, end time.Time) (*InvoicesAPI, error) {
+	params := url.Values{
+		"owner":       {"me"},
+		"sdate":       {strconv.FormatInt(start.Unix(), 10)},
+		"edate":       {strconv.FormatInt(end.Unix(), 10)},
+		"inc_charges": {"true"},
+	}
+	var invoices InvoicesAPI
+	if err := c.get(ctx, "users/me/invoices", params, &invoices); err != nil {
+		return nil, err
+	}
+	return &invoices, nil
+}
+
+// Instances returns the instances rented by the user.
+func (c *Client) Instances(ctx context.Context) (*InstancesAPI, error) {
+	var instances InstancesAPI
+	if err := c.get(ctx, "instances", url.Values{"owner": {"me"}}, &instances); err != nil {
+		return nil, err
+	}
+	return &instances, nil
+}
+
+// Offers returns the public on-demand marketplace offers of a GPU model,
+// including offers that are currently rented. The search leaves out rented
+// offers unless asked for them, so rented and unrented offers are
+// requested separately.
+func (c *Client) Offers(ctx context.Context, gpuName string) (*OffersAPI, error) {
+	result := &OffersAPI{}
+	for _, rented := range []bool{false, true} {
+		q, err := json.Marshal(map[string]interface{}{
+			"gpu_name":         map[string]interface{}{"eq": gpuName},
+			"external":         map[string]interface{}{"eq": false},
+			"rented":           map[string]interface{}{"eq": rented},
+			"type":             "on-demand",
+			"disable_bundling": true,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var offers OffersAPI
+		if err := c.get(ctx, "bundles/", url.Values{"q": {string(q)}}, &offers); err != nil {
+			return nil, err
+		}
+		result.Offers = append(result.Offers, offers.Offers...)
+	}
+	return result, nil
+}
+
+// get fetches path, relative to the base URL, and decodes the JSON
+// response body into v. The API key never appears in returned errors.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v interface{}) error {
+	apiKey := c.getAPIKey()
+	if err := c.doGet(ctx, apiKey, path, query, v); err != nil {
+		return redact(err, apiKey)
+	}
+	return nil
+}
+
+func (c *Client) doGet(ctx context.Context, apiKey, path string, query url.Values, v interface{}) error {
+	u := c.baseURL.ResolveReference(&url.URL{Path: path, RawQuery: query.Encode()})
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	// The key is sent as a header rather than the api_key query parameter,
+	// so that it does not end up in proxy logs or in url.Error messages.
+	req.Header.Set("Authorization", "Bearer "+apiKey)
+	req.Header.Set("Accept", "application/json")
+	if c.userAgent != "" {
+		req.Header.Set("User-Agent", c.userAgent)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected response status for %s: %s", path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to decode JSON response from %s: %w", path, err)
+	}
+	return nil
+}
+
+// redact hides the API key in the message of err, in case a server
+// echoed it back somewhere, e.g. in a redirect URL.
+func redact(err error, apiKey string) error {
+	if apiKey == "" || !strings.Contains(err.Error(), apiKey) {
+		return err
+	}
+	return &redactedError{err: err, apiKey: apiKey}
+}
+
+type redactedError struct {
+	err    error
+	apiKey string
+}
+
+func (e *redactedError) Error() string {
+	return strings.ReplaceAll(e.err.Error(), e.apiKey, "<redacted>")
+}
+
+func (e *redactedError) Unwrap() error {
+	return e.err
+}
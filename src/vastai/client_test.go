@@ -0,0 +1,252 @@
+package vastai
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testAPIKey = "0123456789abcdef-test-api-key"
+
+// serveFile responds with a recorded response from the testdata of the
+// exporter.
+func serveFile(t *testing.T, name string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		data, err := ioutil.ReadFile(filepath.Join("..", "testdata", "full", name))
+		if err != nil {
+			t.Error(err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func newTestClient(t *testing.T, baseURL string) *Client {
+	client, err := NewClient(Config{BaseURL: baseURL, APIKey: testAPIKey, UserAgent: "test-agent"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestNewClientBaseURL(t *testing.T) {
+	for _, baseURL := range []string{"/api/v0", "/api/v0/"} {
+		var path string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			path = r.URL.Path
+			w.Write([]byte(`{"balance": 1}`))
+		}))
+		_, err := newTestClient(t, server.URL+baseURL).Account(context.Background())
+		server.Close()
+		if err != nil {
+			t.Fatalf("base URL %q: %s", baseURL, err)
+		}
+		if path != "/api/v0/users/current" {
+			t.Errorf("base URL %q: requested %s, want /api/v0/users/current", baseURL, path)
+		}
+	}
+
+	for _, baseURL := range []string{"console.vast.ai/api/v0/", "ftp://console.vast.ai/", "http://[::1"} {
+		if _, err := NewClient(Config{BaseURL: baseURL}); err == nil {
+			t.Errorf("base URL %q: expected an error", baseURL)
+		}
+	}
+}
+
+func TestRequestHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for header, want := range map[string]string{
+			"Authorization": "Bearer " + testAPIKey,
+			"Accept":        "application/json",
+			"User-Agent":    "test-agent",
+		} {
+			if got := r.Header.Get(header); got != want {
+				t.Errorf("%s = %q, want %q", header, got, want)
+			}
+		}
+		if r.URL.Query().Get("api_key") != "" {
+			t.Error("API key sent as query parameter")
+		}
+		w.Write([]byte(`{"balance": 1}`))
+	}))
+	defer server.Close()
+
+	if _, err := newTestClient(t, server.URL).Account(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSetAPIKey(t *testing.T) {
+	var got string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Get("Authorization")
+		w.Write([]byte(`{"balance": 1}`))
+	}))
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	client.SetAPIKey("rotated")
+	if _, err := client.Account(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got != "Bearer rotated" {
+		t.Errorf("Authorization = %q, want the rotated key", got)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for name, handler := range map[string]http.HandlerFunc{
+		"status": func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "<html>502 Bad Gateway</html>", http.StatusBadGateway)
+		},
+		"malformed": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"machines": [{"machine_id": 1,`))
+		},
+		"mistyped": func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`{"machines": [{"machine_id": "one"}]}`))
+		},
+		"redirect": func(w http.ResponseWriter, r *http.Request) {
+			http.Redirect(w, r, "http://127.0.0.1:0/?api_key="+testAPIKey, http.StatusFound)
+		},
+	} {
+		server := httptest.NewServer(handler)
+		_, err := newTestClient(t, server.URL).Machines(context.Background())
+		server.Close()
+		if err == nil {
+			t.Errorf("%s: expected an error", name)
+			continue
+		}
+		if strings.Contains(err.Error(), testAPIKey) {
+			t.Errorf("%s: API key found in error %q", name, err)
+		}
+	}
+}
+
+func TestOffersQuery(t *testing.T) {
+	var queries []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/bundles/" {
+			t.Errorf("requested %s, want /bundles/", r.URL.Path)
+		}
+		var query map[string]interface{}
+		if err := json.Unmarshal([]byte(r.URL.Query().Get("q")), &query); err != nil {
+			t.Errorf("invalid q parameter: %s", err)
+		}
+		queries = append(queries, query)
+		serveFile(t, "offers.json")(w, r)
+	}))
+	defer server.Close()
+
+	offers, err := newTestClient(t, server.URL).Offers(context.Background(), "RTX 4090")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 {
+		t.Fatalf("sent %d queries, want one for unrented and one for rented offers", len(queries))
+	}
+	if len(offers.Offers) == 0 || len(offers.Offers)%2 != 0 {
+		t.Errorf("decoded %d offers, want the offers of both queries", len(offers.Offers))
+	}
+	for i, query := range queries {
+		gpuName, _ := query["gpu_name"].(map[string]interface{})
+		if gpuName["eq"] != "RTX 4090" {
+			t.Errorf("gpu_name filter = %v, want eq RTX 4090", query["gpu_name"])
+		}
+		if query["type"] != "on-demand" {
+			t.Errorf("type = %v, want on-demand", query["type"])
+		}
+		rented, _ := query["rented"].(map[string]interface{})
+		if want := i == 1; rented["eq"] != want {
+			t.Errorf("rented filter of query %d = %v, want eq %v", i, query["rented"], want)
+		}
+	}
+}
+
+func TestMachineEarningsBetweenQuery(t *testing.T) {
+	var query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query().Encode()
+		serveFile(t, "machine_earnings.json")(w, r)
+	}))
+	defer server.Close()
+	client := newTestClient(t, server.URL)
+
+	if _, err := client.MachineEarningsBetween(context.Background(), EarningsQuery{StartDay: 19626, EndDay: 19627}); err != nil {
+		t.Fatal(err)
+	}
+	if query != "eday=19627&sday=19626" {
+		t.Errorf("query = %s, want eday=19627&sday=19626", query)
+	}
+	if _, err := client.MachineEarningsBetween(context.Background(), EarningsQuery{StartDay: 19626, EndDay: 19627, MachineID: 10423}); err != nil {
+		t.Fatal(err)
+	}
+	if query != "eday=19627&machid=10423&sday=19626" {
+		t.Errorf("query = %s, want eday=19627&machid=10423&sday=19626", query)
+	}
+}
+
+// TestDecodeFixtures checks that the recorded responses decode into the
+// API types, so that changes in the shape of the API show up here first.
+func TestDecodeFixtures(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/machines/", serveFile(t, "machines.json"))
+	mux.Handle("/users/me/machine-earnings", serveFile(t, "machine_earnings.json"))
+	mux.Handle("/users/current", serveFile(t, "account.json"))
+	mux.Handle("/instances", serveFile(t, "instances.json"))
+	mux.Handle("/users/me/invoices", serveFile(t, "invoices.json"))
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	client := newTestClient(t, server.URL)
+	ctx := context.Background()
+
+	machines, err := client.Machines(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(machines.Machines) == 0 || machines.Machines[0].MachineID != 10423 || machines.Machines[0].GpuName != "RTX 4090" {
+		t.Errorf("unexpected machines: %+v", machines.Machines)
+	}
+	if len(machines.Machines[0].Clients) == 0 {
+		t.Error("no machine clients decoded")
+	}
+
+	earnings, err := client.MachineEarnings(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(earnings.PerMachine) != 2 || len(earnings.PerDay) != 2 {
+		t.Errorf("unexpected earnings: %+v", earnings)
+	}
+
+	account, err := client.Account(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.Balance != 512.77 || account.PaidExpected != 312.4 || !account.CanPay {
+		t.Errorf("unexpected account: %+v", account)
+	}
+
+	invoices, err := client.Invoices(ctx, time.Unix(1690000000, 0), time.Unix(1696003260, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(invoices.Invoices) != 5 || invoices.Invoices[2].Type != "payout" || invoices.Invoices[2].Amount != 301.17 {
+		t.Errorf("unexpected invoices: %+v", invoices.Invoices)
+	}
+
+	instances, err := client.Instances(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(instances.Instances) == 0 {
+		t.Error("no instances decoded")
+	}
+}
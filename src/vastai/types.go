@@ -0,0 +1,204 @@
+package vastai
+
+// MachineEarningsAPI is the response of the machine-earnings endpoint.
+type MachineEarningsAPI struct {
+	Summary struct {
+		TotalGpu  float64 `json:"total_gpu"`
+		TotalStor float64 `json:"total_stor"`
+		TotalBwu  float64 `json:"total_bwu"`
+		TotalBwd  float64 `json:"total_bwd"`
+	} `json:"summary"`
+	Current struct {
+		Balance    float64 `json:"balance"`
+		ServiceFee float64 `json:"service_fee"`
+		Total      float64 `json:"total"`
+		Credit     float64 `json:"credit"`
+	} `json:"current"`
+	PerMachine []struct {
+		MachineID int     `json:"machine_id"`
+		GpuEarn   float64 `json:"gpu_earn"`
+		StoEarn   float64 `json:"sto_earn"`
+		BwuEarn   float64 `json:"bwu_earn"`
+		BwdEarn   float64 `json:"bwd_earn"`
+	} `json:"per_machine"`
+	PerDay []struct {
+		Day     int     `json:"day"`
+		GpuEarn float64 `json:"gpu_earn"`
+		StoEarn float64 `json:"sto_earn"`
+		BwuEarn float64 `json:"bwu_earn"`
+		BwdEarn float64 `json:"bwd_earn"`
+	} `json:"per_day"`
+}
+
+// MachinesAPI is the response of the machines endpoint.
+type MachinesAPI struct {
+	Machines []Machine `json:"machines"`
+}
+
+// Machine is one of the user's hosted machines.
+type Machine struct {
+	MachineID                     int             `json:"machine_id"`
+	Hostname                      string          `json:"hostname"`
+	Geolocation                   string          `json:"geolocation"`
+	Timeout                       float64         `json:"timeout"`
+	MoboName                      string          `json:"mobo_name"`
+	NumGpus                       int             `json:"num_gpus"`
+	TotalFlops                    float64         `json:"total_flops"`
+	Dlperf                        float64         `json:"dlperf"`
+	GpuName                       string          `json:"gpu_name"`
+	GpuRAM                        int             `json:"gpu_ram"`
+	GpuMaxCurTemp                 float64         `json:"gpu_max_cur_temp"`
+	GpuLanes                      int             `json:"gpu_lanes"`
+	GpuMemBw                      float64         `json:"gpu_mem_bw"`
+	BwNvlink                      float64         `json:"bw_nvlink"`
+	PcieBw                        float64         `json:"pcie_bw"`
+	PciGen                        float64         `json:"pci_gen"`
+	CPUName                       string          `json:"cpu_name"`
+	CPURAM                        int             `json:"cpu_ram"`
+	CPUCores                      int             `json:"cpu_cores"`
+	Listed                        bool            `json:"listed"`
+	StartDate                     float64         `json:"start_date"` // UNIX timestamp
+	EndDate                       float64         `json:"end_date"`   // UNIX timestamp
+	ListedMinGpuCount             int             `json:"listed_min_gpu_count"`
+	ListedGpuCost                 float64         `json:"listed_gpu_cost"`
+	ListedStorageCost             float64         `json:"listed_storage_cost"`
+	ListedInetUpCost              float64         `json:"listed_inet_up_cost"`
+	ListedInetDownCost            float64         `json:"listed_inet_down_cost"`
+	MinBidPrice                   float64         `json:"min_bid_price"`
+	GpuOccupancy                  string          `json:"gpu_occupancy"`
+	BidGpuCost                    float64         `json:"bid_gpu_cost"`
+	BidImage                      string          `json:"bid_image"`
+	BidImageArgs                  []string        `json:"bid_image_args"`
+	BidImageArgsStr               string          `json:"bid_image_args_str"`
+	DiskSpace                     int             `json:"disk_space"`
+	MaxDiskSpace                  int             `json:"max_disk_space"`
+	AllocDiskSpace                int             `json:"alloc_disk_space"`
+	AvailDiskSpace                int             `json:"avail_disk_space"`
+	DiskName                      string          `json:"disk_name"`
+	DiskBw                        float64         `json:"disk_bw"`
+	InetUp                        float64         `json:"inet_up"`
+	InetDown                      float64         `json:"inet_down"`
+	EarnHour                      float64         `json:"earn_hour"`
+	EarnDay                       float64         `json:"earn_day"`
+	Verification                  string          `json:"verification"`
+	ErrorDescription              string          `json:"error_description"`
+	CurrentRentalsRunning         int             `json:"current_rentals_running"`
+	CurrentRentalsRunningOnDemand int             `json:"current_rentals_running_on_demand"`
+	CurrentRentalsResident        int             `json:"current_rentals_resident"`
+	CurrentRentalsOnDemand        int             `json:"current_rentals_on_demand"`
+	Reliability2                  float64         `json:"reliability2"`
+	DirectPortCount               int             `json:"direct_port_count"`
+	PublicIPAddr                  string          `json:"public_ipaddr"`
+	Clients                       []MachineClient `json:"clients"` // New nested struct
+}
+
+// MachineClient is a rental contract running on a machine.
+type MachineClient struct {
+	ID               int     `json:"id"`
+	LastUpdate       float64 `json:"last_update"` // Changed to float64 to handle decimal values
+	LastProc         float64 `json:"last_proc"`   // Changed to float64 to handle decimal values
+	Type             string  `json:"type"`
+	Label            string  `json:"label"`
+	ClientID         int     `json:"client_id"`
+	HostID           int     `json:"host_id"`
+	CreatedAt        float64 `json:"created_at"`         // Changed to float64
+	DeletedAt        float64 `json:"deleted_at"`         // Changed to float64
+	StartDate        float64 `json:"start_date"`         // Changed to float64
+	EndDate          float64 `json:"end_date"`           // Changed to float64
+	ClientRunTime    float64 `json:"client_run_time"`    // Changed to float64 to handle decimal values
+	ClientStopTime   float64 `json:"client_stop_time"`   // Changed to float64 to handle decimal values
+	ClientUnloadTime float64 `json:"client_unload_time"` // Changed to float64 to handle decimal values
+	HostRunTime      float64 `json:"host_run_time"`      // Changed to float64 to handle decimal values
+	HostStopTime     float64 `json:"host_stop_time"`     // Changed to float64 to handle decimal values
+	HostUnloadTime   float64 `json:"host_unload_time"`   // Changed to float64 to handle decimal values
+	NextTransDate    float64 `json:"next_trans_date"`    // Changed to float64
+	MaxSpend         float64 `json:"max_spend"`
+	CreatedFrom      int     `json:"created_from"`
+	BundleID         int     `json:"bundle_id"`
+	LastBillup       float64 `json:"last_billup"` // Changed to float64
+	IsSystem         float64 `json:"is_system"`
+	EarnSec          float64 `json:"earn_sec"`
+	EarnMin          float64 `json:"earn_min"`
+	EarnHour         float64 `json:"earn_hour"`
+	EarnDay          float64 `json:"earn_day"`
+	LossSec          float64 `json:"loss_sec"`
+	LossMin          float64 `json:"loss_min"`
+	LossHour         float64 `json:"loss_hour"`
+	LossDay          float64 `json:"loss_day"`
+	MinBidPrice      float64 `json:"min_bid_price"`
+	Dlperf           float64 `json:"dlperf"`
+}
+
+// AccountAPI is the response of the current user endpoint.
+type AccountAPI struct {
+	Balance float64 `json:"balance"`
+	Credit  float64 `json:"credit"`
+	// Payouts of hosting earnings: verified ones were paid out, expected
+	// ones are pending.
+	PaidVerified float64 `json:"paid_verified"`
+	PaidExpected float64 `json:"paid_expected"`
+	// Charges for rented instances, verified and expected.
+	BilledVerified float64 `json:"billed_verified"`
+	BilledExpected float64 `json:"billed_expected"`
+	// BalanceThreshold is the balance below which the account is topped
+	// up, if BalanceThresholdEnabled.
+	BalanceThreshold        float64 `json:"balance_threshold"`
+	BalanceThresholdEnabled bool    `json:"balance_threshold_enabled"`
+	BillingCreditOnly       bool    `json:"billing_creditonly"`
+	HasBilling              bool    `json:"has_billing"`
+	CanPay                  bool    `json:"can_pay"`
+	EmailVerified           bool    `json:"email_verified"`
+}
+
+// InvoicesAPI is the response of the invoices endpoint.
+type InvoicesAPI struct {
+	Invoices []Invoice `json:"invoices"`
+}
+
+// Invoice is a charge, deposit or payout of the account.
+type Invoice struct {
+	ID          int     `json:"id"`
+	Type        string  `json:"type"`
+	Amount      float64 `json:"amount"`
+	Timestamp   float64 `json:"timestamp"` // UNIX timestamp
+	Description string  `json:"description"`
+	IsCredit    bool    `json:"is_credit"`
+}
+
+// OffersAPI is the response of the offers search endpoint.
+type OffersAPI struct {
+	Offers []Offer `json:"offers"`
+}
+
+// Offer is a machine, or part of one, offered on the marketplace.
+type Offer struct {
+	ID           int     `json:"id"`
+	MachineID    int     `json:"machine_id"`
+	GpuName      string  `json:"gpu_name"`
+	NumGpus      int     `json:"num_gpus"`
+	DphBase      float64 `json:"dph_base"` // On-demand price per hour of the whole offer, without storage and bandwidth
+	Dlperf       float64 `json:"dlperf"`
+	Verification string  `json:"verification"`
+	Rentable     bool    `json:"rentable"`
+	Rented       bool    `json:"rented"`
+}
+
+// InstancesAPI is the response of the instances endpoint.
+type InstancesAPI struct {
+	Instances []Instance `json:"instances"`
+}
+
+// Instance is an instance rented by the user.
+type Instance struct {
+	ID             int     `json:"id"`
+	MachineID      int     `json:"machine_id"`
+	Label          string  `json:"label"`
+	ActualStatus   string  `json:"actual_status"` // e.g. "running", "loading", "exited"
+	IntendedStatus string  `json:"intended_status"`
+	NumGpus        int     `json:"num_gpus"`
+	GpuName        string  `json:"gpu_name"`
+	DphTotal       float64 `json:"dph_total"`  // Current cost per hour, including storage and bandwidth
+	StartDate      float64 `json:"start_date"` // UNIX timestamp
+	ImageUUID      string  `json:"image_uuid"`
+	IsBid          bool    `json:"is_bid"`
+}